@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+	"github.com/mrojasb2000/greenlight/internal/validator"
+)
+
+// The isbnReplacer strips the hyphens and spaces that are commonly used to format
+// ISBNs, so that clients can send "978-0-13-468599-1" as well as "9780134685991".
+var isbnReplacer = strings.NewReplacer("-", "", " ", "")
+
+// Add a createBookHandler for the "POST /v1/books" endpoint.
+func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
+	// Declare an anonymous struct to hold the information that we expect to be in the
+	// HTTP request body.
+	var input struct {
+		Title         string   `json:"title"`
+		Authors       []string `json:"authors"`
+		ISBN          string   `json:"isbn"`
+		PublishedYear int32    `json:"published_year"`
+		Pages         int32    `json:"pages"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badResquestResponse(w, r, err)
+		return
+	}
+
+	// Copy the values from the input struct to a new Book struct.
+	book := &data.Book{
+		Title:         input.Title,
+		Authors:       input.Authors,
+		ISBN:          isbnReplacer.Replace(input.ISBN),
+		PublishedYear: input.PublishedYear,
+		Pages:         input.Pages,
+	}
+
+	v := validator.New()
+
+	if data.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Insert the book, returning a validation error if a book with the same ISBN
+	// already exists.
+	err = app.models.Books.Insert(r.Context(), book)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateISBN):
+			v.AddError("isbn", "a book with this ISBN already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Include a Location header with the URL of the newly-created book.
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/books/%d", book.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a listBooksHandler for the "GET /v1/books" endpoint. Like listMoviesHandler, it
+// supports filtering, sorting and pagination with query string parameters: title and
+// author filter the books, and page, page_size and sort work in the same way.
+func (app *application) listBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Author string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Author = app.readString(qs, "author", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "title", "published_year", "pages", "-id", "-title", "-published_year", "-pages"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	books, metadata, err := app.models.Books.GetAll(r.Context(), input.Title, input.Author, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a showBookHandler for the "GET /v1/books/:id" endpoint.
+func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := app.models.Books.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add an updateBookHandler for the "PATCH /v1/books/:id" endpoint. Like the movies
+// endpoint, this supports partial updates.
+func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Fetch the existing book record from the database.
+	book, err := app.models.Books.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Use pointers for the fields in the input struct, so that we can tell whether a
+	// field was provided in the request body or not.
+	var input struct {
+		Title         *string  `json:"title"`
+		Authors       []string `json:"authors"`
+		ISBN          *string  `json:"isbn"`
+		PublishedYear *int32   `json:"published_year"`
+		Pages         *int32   `json:"pages"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badResquestResponse(w, r, err)
+		return
+	}
+
+	// Copy the values which were provided to the book record.
+	if input.Title != nil {
+		book.Title = *input.Title
+	}
+	if input.Authors != nil {
+		book.Authors = input.Authors
+	}
+	if input.ISBN != nil {
+		book.ISBN = isbnReplacer.Replace(*input.ISBN)
+	}
+	if input.PublishedYear != nil {
+		book.PublishedYear = *input.PublishedYear
+	}
+	if input.Pages != nil {
+		book.Pages = *input.Pages
+	}
+
+	v := validator.New()
+
+	if data.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Pass the updated book record to the Update() method, intercepting any
+	// ErrEditConflict or ErrDuplicateISBN errors.
+	err = app.models.Books.Update(r.Context(), book)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateISBN):
+			v.AddError("isbn", "a book with this ISBN already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a deleteBookHandler for the "DELETE /v1/books/:id" endpoint.
+func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Books.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
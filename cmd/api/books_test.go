@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+)
+
+func TestBookHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "books:read", "books:write")
+	_, reader := newTestUser(t, app, "reader@example.com", true, "books:read")
+
+	// Each test case gets its own book, which is deleted before the request is made if
+	// deleted is true. Any "{id}" in the URL is replaced with the ID of the book, and
+	// any "{isbn}" in the body with its ISBN.
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		body     string
+		token    string
+		deleted  bool
+		wantCode int
+		wantBody string
+	}{
+		{"Create", http.MethodPost, "/v1/books", `{"title": "The Go Programming Language", "authors": ["Alan Donovan", "Brian Kernighan"], "isbn": "978-0-13-419044-0", "published_year": 2015, "pages": 380}`, writer, false, http.StatusCreated, `"isbn":"9780134190440"`},
+		{"Create unauthenticated", http.MethodPost, "/v1/books", `{}`, "", false, http.StatusUnauthorized, "you must be authenticated"},
+		{"Create without permission", http.MethodPost, "/v1/books", `{}`, reader, false, http.StatusForbidden, "necessary permissions"},
+		{"Create invalid ISBN", http.MethodPost, "/v1/books", `{"title": "Go", "authors": ["Alan Donovan"], "isbn": "9780134190441", "published_year": 2015, "pages": 380}`, writer, false, http.StatusUnprocessableEntity, "must be a valid ISBN-13"},
+		{"Create duplicate ISBN", http.MethodPost, "/v1/books", `{"title": "Go", "authors": ["Alan Donovan"], "isbn": "{isbn}", "published_year": 2015, "pages": 380}`, writer, false, http.StatusUnprocessableEntity, "a book with this ISBN already exists"},
+		{"Create missing authors", http.MethodPost, "/v1/books", `{"title": "Go", "isbn": "9780134190440", "published_year": 2015, "pages": 380}`, writer, false, http.StatusUnprocessableEntity, `"authors":"must be provided"`},
+		{"Show", http.MethodGet, "/v1/books/{id}", "", reader, false, http.StatusOK, `"title":"The Go Programming Language"`},
+		{"Show missing", http.MethodGet, "/v1/books/999999", "", reader, false, http.StatusNotFound, "could not be found"},
+		{"Update", http.MethodPatch, "/v1/books/{id}", `{"pages": 400}`, writer, false, http.StatusOK, `"pages":400,"version":2`},
+		{"Update invalid", http.MethodPatch, "/v1/books/{id}", `{"published_year": 3000}`, writer, false, http.StatusUnprocessableEntity, "must not be in the future"},
+		{"Update missing", http.MethodPatch, "/v1/books/999999", `{"pages": 400}`, writer, false, http.StatusNotFound, "could not be found"},
+		{"Delete", http.MethodDelete, "/v1/books/{id}", "", writer, false, http.StatusOK, "book successfully deleted"},
+		{"Delete missing", http.MethodDelete, "/v1/books/{id}", "", writer, true, http.StatusNotFound, "could not be found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestBook(t, app)
+
+			if tt.deleted {
+				err := app.models.Books.Delete(context.Background(), book.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reqBody := strings.ReplaceAll(tt.body, "{isbn}", book.ISBN)
+
+			code, _, body := ts.do(t, tt.method, withID(tt.urlPath, book.ID), reqBody, tt.token)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestListBooks(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, reader := newTestUser(t, app, "reader@example.com", true, "books:read")
+	_, noPermission := newTestUser(t, app, "movies@example.com", true, "movies:read")
+
+	for _, book := range []*data.Book{
+		{Title: "The Go Programming Language", Authors: []string{"Alan Donovan", "Brian Kernighan"}, ISBN: "9780134190440", PublishedYear: 2015, Pages: 380},
+		{Title: "The C Programming Language", Authors: []string{"Brian Kernighan", "Dennis Ritchie"}, ISBN: "9780131103627", PublishedYear: 1988, Pages: 272},
+		{Title: "Let's Go", Authors: []string{"Alex Edwards"}, ISBN: "9780000000002", PublishedYear: 2021, Pages: 450},
+	} {
+		if err := app.models.Books.Insert(context.Background(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		token    string
+		wantCode int
+		wantIDs  []int64
+		wantBody string
+	}{
+		{"All", "/v1/books", reader, http.StatusOK, []int64{1, 2, 3}, `"total_records":3`},
+		{"Title", "/v1/books?title=let's+go", reader, http.StatusOK, []int64{3}, ""},
+		{"Author", "/v1/books?author=brian+kernighan", reader, http.StatusOK, []int64{1, 2}, ""},
+		{"Sort", "/v1/books?sort=-pages", reader, http.StatusOK, []int64{3, 1, 2}, ""},
+		{"Page", "/v1/books?sort=published_year&page=2&page_size=2", reader, http.StatusOK, []int64{3}, `"last_page":2`},
+		{"Invalid sort", "/v1/books?sort=isbn", reader, http.StatusUnprocessableEntity, nil, "invalid sort value"},
+		{"Without permission", "/v1/books", noPermission, http.StatusForbidden, nil, "necessary permissions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, tt.urlPath, "", tt.token)
+
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+			if tt.wantIDs == nil {
+				return
+			}
+
+			var response struct {
+				Books []struct {
+					ID int64 `json:"id"`
+				} `json:"books"`
+			}
+			decode(t, body, &response)
+
+			var ids []int64
+			for _, book := range response.Books {
+				ids = append(ids, book.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("got books %v; want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+)
+
+// Define a custom contextKey type, with the underlying type string.
+type contextKey string
+
+// Convert the string "user" to a contextKey type and assign it to the userContextKey
+// constant. We'll use this constant as the key for getting and setting user information
+// in the request context.
+const userContextKey = contextKey("user")
+
+// Likewise, the requestInfoContextKey constant is the key for the requestInfo struct,
+// which is added by the requestID() middleware.
+const requestInfoContextKey = contextKey("request_info")
+
+// The requestInfo struct holds details of the request which are needed by code running
+// before the middleware that finds them out. The requestID() middleware adds a pointer
+// to one to the request context, before any of the other middleware, so changes made
+// to it later on are visible to the earlier middleware too. In particular, the
+// recoverPanic() middleware runs before authenticate(), so the user is recorded here
+// for logError() to include in the log entry for a panic.
+type requestInfo struct {
+	id   string
+	user *data.User
+}
+
+// The contextSetUser() method returns a new copy of the request with the provided
+// User struct added to the context. Note that we use our userContextKey constant as the
+// key. The user is also recorded in the requestInfo struct, if there is one.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.user = user
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() retrieves the User struct from the request context. The only
+// time that we'll use this helper is when we logically expect there to be User struct
+// value in the context, and if it doesn't exist it will firmly be an 'unexpected' error,
+// so it's OK to panic in those circumstances.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// The contextSetRequestID() method returns a new copy of the request with a new
+// requestInfo struct holding the provided request ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, &requestInfo{id: id})
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method retrieves the request ID from the request context.
+// Unlike contextGetUser() it doesn't panic, because it's called from logError(), which
+// may run before the requestID() middleware has been reached. In that case it returns
+// the empty string.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return info.id
+	}
+
+	return ""
+}
+
+// The contextGetLoggedUser() method returns the user who made the request, for
+// including in log entries. If the request context doesn't hold the user, because the
+// caller runs before the authenticate() middleware, then we use the user recorded in
+// the requestInfo struct instead. It returns nil if the user isn't known yet.
+func (app *application) contextGetLoggedUser(r *http.Request) *data.User {
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok {
+		return user
+	}
+
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return info.user
+	}
+
+	return nil
+}
+
+// The contextWithActor() method returns the request context with a data.Actor added to
+// it, holding the ID of the authenticated user and the client IP address. The movie
+// model methods record the actor with each revision, so this context should be passed
+// to any of them which change a movie.
+func (app *application) contextWithActor(r *http.Request) context.Context {
+	var actor data.Actor
+
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+		actor.UserID = user.ID
+	}
+
+	// If the client IP address can't be determined we still record the change, just
+	// without the client.
+	actor.Client, _ = app.clientIP(r)
+
+	return data.ContextWithActor(r.Context(), actor)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// The debugVarsHandler() writes all of the published expvar variables as a JSON
+// object, in the same format as the handler from the expvar package. The difference is
+// that we skip the "cmdline" variable which expvar publishes by default, because the
+// command-line flags can contain secrets like the database DSN and SMTP password.
+func (app *application) debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	w.Write([]byte("{\n"))
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			w.Write([]byte(",\n"))
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	w.Write([]byte("\n}\n"))
+}
+
+// The requireDebugAccess() middleware only allows access to the debug endpoints when
+// they have been enabled for everyone with the -debug-vars flag, or when the client IP
+// address is in one of the -debug-vars-allowed-ips networks. Any other clients get a
+// 404 Not Found response, so that the existence of the endpoint isn't revealed.
+//
+// Behind a trusted proxy, clientIP() only uses the address which the proxy added to the
+// request, and never one sent by the client, so the allowlist can't be bypassed by
+// sending a forged X-Forwarded-For header. We can't use r.RemoteAddr instead, because
+// that would be the address of the proxy for every request.
+func (app *application) requireDebugAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.debug.vars {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, err := app.clientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if addr := net.ParseIP(ip); addr != nil {
+			for _, network := range app.config.debug.allowedIPs {
+				if network.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		app.notFoundResponse(w, r)
+	}
+}
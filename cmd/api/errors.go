@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+	"golang.org/x/time/rate"
 )
 
+// Define a constant for the 499 Client Closed Request status code, which isn't defined
+// by the net/http package.
+const statusClientClosedRequest = 499
+
 // Note that the errors parameter here has the type map[string]string, which is exactly
 // the same as the errors map contained in our Validator type.
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
@@ -16,12 +28,28 @@ func (app *application) badResquestResponse(w http.ResponseWriter, r *http.Reque
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-// The logError() method is a generic helper for logging an error message. Later in the
-// book we'll upgrade this to use structured logging, and record additional information
-// about the request including the HTTP method and URL.
-
+// The logError() method is a generic helper for logging an error message at the ERROR
+// level. Along with the error we record the request method and URL, the request ID and,
+// if the request has been authenticated, the ID of the user who made it, so that each
+// log entry can be traced back to the request that failed.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	properties := map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+	}
+
+	if id := app.contextGetRequestID(r); id != "" {
+		properties["request_id"] = id
+	}
+
+	// We can't use contextGetUser() here, because logError() may be called before the
+	// authenticate() middleware has added the user to the request context, for example
+	// by recoverPanic(). contextGetLoggedUser() finds the user in that case too.
+	if user := app.contextGetLoggedUser(r); user != nil && !user.IsAnonymous() {
+		properties["user_id"] = strconv.FormatInt(user.ID, 10)
+	}
+
+	app.logger.PrintError(err, properties)
 }
 
 // The errorResponse() method is a generic helper for sending JSON-formatted error
@@ -46,14 +74,52 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 // unexpected problem at runtime. It logs the detailed error message, then uses the
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client.
-
+//
+// If the error is a cancellation caused by the client closing the connection before we
+// could respond, then it's just a consequence of that rather than an actual problem, so
+// we hand it off to the requestCanceledResponse() helper instead. Any other error is
+// still logged as an error, even if the client has gone away in the meantime.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if isRequestCanceled(r, err) {
+		app.requestCanceledResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encontered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+// The isRequestCanceled() function reports whether err was caused by the request being
+// cancelled. That's either a context.Canceled error, or the error which PostgreSQL
+// returns when lib/pq aborts a query because the request context is done. Both can
+// also come from somewhere else (a context.Canceled from some other context, or an
+// expired query timeout), so we only count them if the request context is done too.
+func isRequestCanceled(r *http.Request, err error) bool {
+	if r.Context().Err() == nil {
+		return false
+	}
+
+	return errors.Is(err, context.Canceled) || data.IsQueryCanceled(err)
+}
+
+// The requestCanceledResponse() method is used when the client has gone away before
+// we finished processing their request. It logs a distinct message at the INFO level,
+// rather than an error with a stack trace, and sends the non-standard 499 Client Closed
+// Request status code (as used by nginx). The client will never see the response, but
+// it's recorded as such in the request metrics.
+func (app *application) requestCanceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.PrintInfo("request canceled by client", map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
+		"error":          err.Error(),
+	})
+
+	w.WriteHeader(statusClientClosedRequest)
+}
+
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 
@@ -77,3 +143,91 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+// The unsupportedMediaTypeResponse() method will be used to send a 415 Unsupported
+// Media Type status code and JSON response when a PATCH request uses a patch format
+// that we don't support. The Accept-Patch header lists the formats which we do support.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+
+	message := fmt.Sprintf("the Content-Type must be one of: %s", acceptPatch)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The patchConflictResponse() method will be used to send a 409 Conflict status code
+// and JSON response when a patch can't be applied to the current state of the record,
+// for example because a JSON Patch test operation failed.
+func (app *application) patchConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+// The preconditionFailedResponse() method will be used to send a 412 Precondition
+// Failed status code and JSON response when the If-Match header doesn't match the
+// current version of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you last fetched it, please try again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// The preconditionRequiredResponse() method will be used to send a 428 Precondition
+// Required status code and JSON response when the -require-if-match flag is set and a
+// request which modifies a record doesn't include an If-Match header.
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+// The rateLimitExceededResponse() method will be used to send a 429 Too Many Requests
+// status code and JSON response when a client has exceeded the rate limit. The
+// Retry-After header tells the client how many seconds to wait before trying again. It
+// is left out if the limiter will never allow the request, in which case the delay is
+// rate.InfDuration.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	if retryAfter != rate.InfDuration {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The invalidCredentialsResponse() method will be used to send a 401 Unauthorized
+// status code and JSON response when the client provides an incorrect email address or
+// password.
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The authenticationRequiredResponse() method will be used to send a 401 Unauthorized
+// status code and JSON response when an anonymous user tries to access an endpoint
+// which requires authentication.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The inactiveAccountResponse() method will be used to send a 403 Forbidden status code
+// and JSON response when an authenticated user hasn't activated their account yet.
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The notPermittedResponse() method will be used to send a 403 Forbidden status code
+// and JSON response when a user doesn't have the permission required for an endpoint.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The invalidAuthenticationTokenResponse() method will be used to send a 401
+// Unauthorized status code and JSON response when the client provides an invalid or
+// expired authentication token. We include a WWW-Authenticate: Bearer header to help
+// inform or remind the client that we expect them to authenticate using a bearer token.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The etag() helper returns the strong entity tag for a record with the given ID and
+// version. Every update increments the version number, so the tag changes whenever the
+// record does, and including the ID means that tags for different records never match.
+func etag(id int64, version int32) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// The etagMatches() helper reports whether the comma-separated list of entity tags in
+// an If-Match or If-None-Match header value contains the given tag, or is "*". When
+// weak is false the strong comparison function is used, so weak tags (prefixed with
+// "W/") never match, as required for If-Match. If-None-Match uses the weak comparison
+// function, which ignores the prefix. See RFC 7232 section 2.3.2.
+func etagMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The checkIfMatch() helper evaluates the If-Match precondition for a request which
+// modifies the record with the given entity tag. If the header is missing and the
+// -require-if-match flag is set, it sends a 428 Precondition Required response, and if
+// the header doesn't match the current tag it sends a 412 Precondition Failed response.
+// It returns false if a response has been sent, in which case the handler should
+// return straight away.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, tag string) bool {
+	header := r.Header.Get("If-Match")
+
+	if header == "" {
+		if app.config.conditional.requireIfMatch {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	if !etagMatches(header, tag, false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// The checkIfNoneMatch() helper evaluates the If-None-Match precondition for a GET
+// request for the record with the given entity tag. If the client's cached copy is
+// still current it sends a 304 Not Modified response, without a body, and returns
+// false.
+func (app *application) checkIfNoneMatch(w http.ResponseWriter, r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+
+	if header != "" && etagMatches(header, tag, true) {
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+
+	return true
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrojasb2000/greenlight/internal/mailer"
 )
 
 // Declare a handler which writes a plain-text response with information about
 // the application status, operating environment and version.
 
+// The version reported here is the same version variable which is set at build time
+// (see main.go). This handler serves both GET /v1/healthcheck, which existing clients
+// rely on, and the GET /v1/healthcheck/live liveness probe. It only tells you that the
+// process is up and able to serve requests; it doesn't check any dependencies.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
-	version := "0.0.1"
-
 	// we've constructed this means the environment and version data will now be nested
 	env := envelope{
 		"status": "available",
@@ -26,3 +36,149 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Define a healthcheck struct to hold the name of a readiness check and the function
+// which performs it.
+type healthcheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// Define a checkResult struct to hold the outcome of a readiness check, as it's shown
+// in the JSON response.
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+}
+
+// The readinessHandler() serves the GET /v1/healthcheck/ready readiness probe. It runs
+// each of the dependency checks concurrently, within the configured timeout, and
+// responds with a 503 Service Unavailable status code if any of them fail, or if the
+// graceful shutdown of the server has begun. The response includes the status and
+// latency of each check. The errors themselves are only logged, because they may
+// contain details about our infrastructure which we don't want to make public.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	// As soon as the server starts shutting down, we report that we're unavailable so
+	// that the load balancer stops sending us new requests.
+	if atomic.LoadInt32(&app.shuttingDown) == 1 {
+		env := envelope{"status": "unavailable", "reason": "server is shutting down"}
+
+		err := app.writeJSON(w, http.StatusServiceUnavailable, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.healthcheck.timeout)
+	defer cancel()
+
+	checks := app.readinessChecks()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]checkResult, len(checks))
+		healthy = true
+	)
+
+	for _, hc := range checks {
+		wg.Add(1)
+
+		go func(hc healthcheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := hc.check(ctx)
+			result := checkResult{Status: "ok", Latency: time.Since(start).String()}
+
+			if err != nil {
+				result.Status = "failed"
+				app.logError(r, fmt.Errorf("readiness check %s: %w", hc.name, err))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[hc.name] = result
+			if err != nil {
+				healthy = false
+			}
+		}(hc)
+	}
+
+	wg.Wait()
+
+	env := envelope{
+		"status": "available",
+		"checks": results,
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+	status := http.StatusOK
+
+	if !healthy {
+		env["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readinessChecks() method returns the checks for the dependencies which the
+// application needs in order to serve requests. The mailer check is only included if
+// the mailer is able to check its connectivity.
+func (app *application) readinessChecks() []healthcheck {
+	checks := []healthcheck{
+		{"database", app.checkDatabase},
+		{"migrations", app.checkMigrations},
+	}
+
+	if pinger, ok := app.mailer.(mailer.Pinger); ok {
+		checks = append(checks, healthcheck{"mailer", pinger.Ping})
+	}
+
+	return checks
+}
+
+// The checkDatabase() method checks that we can connect to the database.
+func (app *application) checkDatabase(ctx context.Context) error {
+	if app.db == nil {
+		return errors.New("no database connection pool")
+	}
+
+	return app.db.PingContext(ctx)
+}
+
+// The checkMigrations() method checks that the database schema has been migrated to
+// (at least) the version that this build of the application expects, and that the
+// last migration didn't fail part way through and leave the schema in a dirty state.
+func (app *application) checkMigrations(ctx context.Context) error {
+	if app.db == nil {
+		return errors.New("no database connection pool")
+	}
+
+	var (
+		current int
+		dirty   bool
+	)
+
+	err := app.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&current, &dirty)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("schema version %d is dirty", current)
+	case current < app.config.healthcheck.migrationVersion:
+		return fmt.Errorf("schema version %d is older than expected version %d", current, app.config.healthcheck.migrationVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthcheckHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	for _, urlPath := range []string{"/v1/healthcheck", "/v1/healthcheck/live"} {
+		code, _, body := ts.do(t, http.MethodGet, urlPath, "", "")
+
+		if code != http.StatusOK {
+			t.Errorf("%s: got status %d; want %d", urlPath, code, http.StatusOK)
+		}
+		if want := `"status":"available"`; !strings.Contains(body, want) {
+			t.Errorf("%s: got body %q; want it to contain %q", urlPath, body, want)
+		}
+	}
+
+	// The test application doesn't have a database connection pool, so the readiness
+	// checks for the database and migrations fail.
+	code, _, body := ts.do(t, http.MethodGet, "/v1/healthcheck/ready", "", "")
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("ready: got status %d; want %d", code, http.StatusServiceUnavailable)
+	}
+	if want := `"database":{"status":"failed"`; !strings.Contains(body, want) {
+		t.Errorf("ready: got body %q; want it to contain %q", body, want)
+	}
+
+	// Once graceful shutdown has begun, readiness reports unavailable without running
+	// any checks.
+	atomic.StoreInt32(&app.shuttingDown, 1)
+
+	code, _, body = ts.do(t, http.MethodGet, "/v1/healthcheck/ready", "", "")
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("ready while shutting down: got status %d; want %d", code, http.StatusServiceUnavailable)
+	}
+	if want := "server is shutting down"; !strings.Contains(body, want) {
+		t.Errorf("ready while shutting down: got body %q; want it to contain %q", body, want)
+	}
+}
+
+func TestDebugVarsHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	code, _, _ := ts.do(t, http.MethodGet, "/debug/vars", "", "")
+	if code != http.StatusNotFound {
+		t.Errorf("disabled: got status %d; want %d", code, http.StatusNotFound)
+	}
+
+	// The test server listens on the loopback address, so allow that network.
+	networks, err := parseNetworks([]string{"127.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config.debug.allowedIPs = networks
+
+	code, _, body := ts.do(t, http.MethodGet, "/debug/vars", "", "")
+	if code != http.StatusOK {
+		t.Errorf("allowed IP: got status %d; want %d", code, http.StatusOK)
+	}
+	if want := `"memstats":`; !strings.Contains(body, want) {
+		t.Errorf("allowed IP: got body without %q", want)
+	}
+	if strings.Contains(body, `"cmdline":`) {
+		t.Error("allowed IP: got body containing cmdline")
+	}
+}
+
+func TestDebugVarsSpoofedForwardedFor(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.trustedProxy = true
+	app.config.limiter.proxyHeader = "X-Forwarded-For"
+	app.config.limiter.proxyHops = 1
+
+	networks, err := parseNetworks([]string{"127.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config.debug.allowedIPs = networks
+
+	ts := newTestServer(t, app.routes())
+
+	// The client claims to be on the loopback network, and the proxy appends the
+	// client's real address.
+	code, _, _ := ts.doWithHeaders(t, http.MethodGet, "/debug/vars", "", "", http.Header{"X-Forwarded-For": {"127.0.0.1, 203.0.113.7"}})
+	if code != http.StatusNotFound {
+		t.Errorf("spoofed: got status %d; want %d", code, http.StatusNotFound)
+	}
+
+	code, _, _ = ts.doWithHeaders(t, http.MethodGet, "/debug/vars", "", "", http.Header{"X-Forwarded-For": {"203.0.113.7, 127.0.0.1"}})
+	if code != http.StatusOK {
+		t.Errorf("allowed: got status %d; want %d", code, http.StatusOK)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, reader := newTestUser(t, app, "reader@example.com", true, "movies:read")
+
+	ts.do(t, http.MethodGet, "/v1/movies/1", "", reader)
+	ts.do(t, http.MethodGet, "/v1/no-such-route", "", "")
+
+	metricsServer := newTestServer(t, app.metricsRoutes())
+
+	code, header, body := metricsServer.do(t, http.MethodGet, "/metrics", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+	if got := header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q", got)
+	}
+
+	for _, want := range []string{
+		`greenlight_http_responses_total{route="/v1/movies/:id",method="GET",status="404"} 1`,
+		`greenlight_http_requests_total{route="unmatched",method="GET"} 1`,
+		`greenlight_http_request_duration_seconds_count{route="/v1/movies/:id",method="GET"} 1`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got metrics without %q", want)
+		}
+	}
+
+	// When a metrics username is configured, scrapers must authenticate.
+	app.config.metrics.username = "prometheus"
+	app.config.metrics.password = "pa55word"
+	metricsServer = newTestServer(t, app.metricsRoutes())
+
+	code, _, _ = metricsServer.do(t, http.MethodGet, "/metrics", "", "")
+	if code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got status %d; want %d", code, http.StatusUnauthorized)
+	}
+}
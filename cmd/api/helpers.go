@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mrojasb2000/greenlight/internal/validator"
 )
 
 // Define an envelope type
@@ -43,7 +46,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		// If there is an error during decoding, start the triage...
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.UnmarshalTypeError
+		var invalidUnmarshalError *json.InvalidUnmarshalError
 
 		switch {
 		// Use the errors.As() method to check whether the error has the type
@@ -172,3 +175,133 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	}
 	return id, nil
 }
+
+// The readVersionParam() helper retrieves the "version" URL parameter from the current
+// request context, in the same way as readIDParam(). Version numbers start at 1, so
+// anything less than that is invalid.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return int32(version), nil
+}
+
+// The readString() helper returns a string value from the query string, or the provided
+// default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	// Extract the value for a given key from the query string. If no key exists this
+	// will return the empty string "".
+	s := qs.Get(key)
+
+	// If no key exists (or the value is empty) then return the default value.
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// The readCSV() helper reads a string value from the query string and then splits it
+// into a slice on the comma character. If no matching key could be found, it returns
+// the provided default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// The background() helper accepts an arbitrary function as a parameter and executes it
+// in a new goroutine, so that slow work like sending emails doesn't hold up the
+// response to the client.
+func (app *application) background(fn func()) {
+	// Increment the WaitGroup counter, so that graceful shutdown waits for the
+	// goroutine to finish.
+	app.wg.Add(1)
+
+	go func() {
+		// Use defer to decrement the WaitGroup counter before the goroutine returns.
+		defer app.wg.Done()
+
+		// Recover any panic in the background goroutine. A panic here would otherwise
+		// terminate the whole application, because it isn't covered by the
+		// recoverPanic() middleware. We log it at the ERROR level instead, and because
+		// we are still on the panicking goroutine the stack trace in the log entry shows
+		// where the panic happened.
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		// Execute the arbitrary function that we passed as the parameter.
+		fn()
+	}()
+}
+
+// The clientIP() helper returns the IP address of the client which made the request.
+// If the API is running behind a trusted reverse proxy, r.RemoteAddr will be the
+// address of the proxy, so we use the header which the proxy sets instead. Proxies
+// append to the X-Forwarded-For header, and any entries before the ones they added
+// were sent by the client, so we count back from the end of the header by the number
+// of trusted proxies. Otherwise these headers are ignored, as any client could set them
+// to avoid the rate limiter.
+func (app *application) clientIP(r *http.Request) (string, error) {
+	if app.config.limiter.trustedProxy {
+		switch app.config.limiter.proxyHeader {
+		case "X-Real-Ip":
+			if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+				return ip, nil
+			}
+		default:
+			// The header may be split over several lines, which are equivalent to a
+			// single comma-separated list.
+			var entries []string
+			for _, line := range r.Header.Values("X-Forwarded-For") {
+				entries = append(entries, strings.Split(line, ",")...)
+			}
+
+			// If there are fewer entries than proxies, the request didn't come through
+			// all of them, so we fall back to r.RemoteAddr.
+			if hops := app.config.limiter.proxyHops; hops >= 1 && len(entries) >= hops {
+				ip := strings.TrimSpace(entries[len(entries)-hops])
+				if net.ParseIP(ip) != nil {
+					return ip, nil
+				}
+			}
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	return ip, nil
+}
@@ -3,18 +3,37 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/mrojasb2000/greenlight/internal/data"
+	"github.com/mrojasb2000/greenlight/internal/jsonlog"
+	"github.com/mrojasb2000/greenlight/internal/mailer"
+	"github.com/mrojasb2000/greenlight/internal/metrics"
+	"github.com/mrojasb2000/greenlight/internal/migrate"
+	"github.com/mrojasb2000/greenlight/migrations"
 )
 
-const version = "1.0.0"
+// Declare variables to hold the application version number, and the git commit and
+// time that the binary was built from. These are set at build time using the -X linker
+// flag (see the Makefile), so they can't be constants. If the binary is built without
+// the Makefile, the version falls back to the value below and the build information
+// is left empty.
+var (
+	version     = "1.0.0"
+	buildCommit string
+	buildTime   string
+)
 
 // Add a db struct field to hold the configuration settigs for our database connection
 // pool. For now the only holds the DSN, which we will read in from a command-line flag.
@@ -23,21 +42,109 @@ const version = "1.0.0"
 // settings for the connection pool.
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port            int
+	env             string
+	logLevel        jsonlog.Level
+	shutdownTimeout time.Duration
+	shutdownDrain   time.Duration
+	db              struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+		automigrate  bool
+	}
+	// Add a new smtp struct field to hold the settings for the SMTP server that we use
+	// to send emails, and a mailer struct field to choose how emails are delivered.
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+		retries  int
+	}
+	mailer struct {
+		backend string
+		dir     string
+	}
+	// Add a new limiter struct containing fields for the requests-per-second and burst
+	// values, a boolean field which we can use to enable/disable rate limiting
+	// altogether, and whether we trust a reverse proxy in front of the API to identify
+	// the client. When we do, proxyHeader is the header which the proxy sets (either
+	// X-Forwarded-For or X-Real-IP), and proxyHops is the number of proxies which append
+	// to the X-Forwarded-For header.
+	limiter struct {
+		rps          float64
+		burst        int
+		enabled      bool
+		trustedProxy bool
+		proxyHeader  string
+		proxyHops    int
+	}
+	// Add a cors struct and trustedOrigins field with the type []string, holding the
+	// origins which browsers are allowed to make cross-origin requests from.
+	cors struct {
+		trustedOrigins []string
+	}
+	// Add a metrics struct holding the port for the separate listener which serves
+	// the Prometheus /metrics endpoint, and the optional basic authentication
+	// credentials that scrapers must provide.
+	metrics struct {
+		port     int
+		username string
+		password string
+	}
+	// Add a healthcheck struct holding the timeout for the readiness checks, and the
+	// database schema version which this build of the application expects. A version of
+	// 0 means the latest migration embedded in the binary.
+	healthcheck struct {
+		timeout          time.Duration
+		migrationVersion int
+	}
+	// Add a purge struct holding how long soft-deleted movies are kept for before the
+	// "purge" command deletes them permanently.
+	purge struct {
+		retention time.Duration
+	}
+	// Add a conditional struct which controls whether requests that modify a record
+	// must include an If-Match header with the record's current ETag.
+	conditional struct {
+		requireIfMatch bool
+	}
+	// Add a debug struct which controls access to the GET /debug/vars endpoint. It is
+	// either enabled for everyone, or only for clients in the allowed networks.
+	debug struct {
+		vars       bool
+		allowedIPs []*net.IPNet
 	}
 }
 
 // Add a models field to hold our new Models struct.
+// Include a mailer field which holds the implementation used to send emails.
+// Include a sync.WaitGroup in the application struct. The zero-value for a
+// sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0,
+// so we don't need to do anything else to initialize it before we can use it.
+// Include the database connection pool, which is only used for its statistics and the
+// healthchecks, and a metrics field to record the HTTP request metrics.
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
+	config  config
+	logger  *jsonlog.Logger
+	db      *sql.DB
+	models  data.Models
+	mailer  mailer.Mailer
+	metrics *metrics.Metrics
+	wg      sync.WaitGroup
+
+	// shuttingDown is set to 1 when graceful shutdown begins. It is read and written
+	// with the sync/atomic functions, because it's accessed from the request handling
+	// goroutines.
+	shuttingDown int32
+
+	// done is closed when the server has stopped, to tell long-running goroutines
+	// (like the rate limiter's cleanup) to exit.
+	done chan struct{}
 }
 
 func main() {
@@ -48,6 +155,23 @@ func main() {
 	// We default to using the port number 4000 and then environment "development" if no corresponding flags are provided
 	flag.IntVar(&cfg.port, "port", 4000, "API Server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	// Read the minimum severity level for log entries. We default to INFO, so DEBUG
+	// entries are only written when they are explicitly asked for.
+	cfg.logLevel = jsonlog.LevelInfo
+	flag.Func("log-level", "Minimum log level (debug|info|error|fatal|off)", func(s string) error {
+		level, err := jsonlog.ParseLevel(s)
+		if err != nil {
+			return err
+		}
+		cfg.logLevel = level
+		return nil
+	})
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 20*time.Second, "Grace period for in-flight requests on shutdown")
+
+	// Read how long to keep serving requests after a shutdown signal, while the readiness
+	// healthcheck reports that the server is unavailable. This gives load balancers time
+	// to notice and stop sending new requests before the listener is closed.
+	flag.DurationVar(&cfg.shutdownDrain, "shutdown-drain-delay", 5*time.Second, "Delay before closing the listener on shutdown, while /v1/healthcheck/ready returns 503")
 
 	// Read the DSN value from the db-dsn command-line flag into the config struct. We
 	// default to using our development DSN if no flag is provided.
@@ -61,50 +185,227 @@ func main() {
 
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
+	// Read the timeout which is applied to each database query. Queries are also
+	// cancelled if the client disconnects or the server shuts down first.
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL per-query timeout")
+
+	// Read whether the pending database migrations should be applied at startup, before
+	// the server starts accepting requests.
+	flag.BoolVar(&cfg.db.automigrate, "db-automigrate", false, "Apply pending database migrations at startup")
+
+	// Create command line flags to read the setting values into the config struct.
+	// Notice that we use true as the default for the 'enabled' setting?
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.BoolVar(&cfg.limiter.trustedProxy, "limiter-trusted-proxy", false, "Use the header set by a trusted reverse proxy to identify clients")
+	flag.IntVar(&cfg.limiter.proxyHops, "limiter-trusted-proxy-hops", 1, "Number of trusted proxies which append to X-Forwarded-For")
+
+	// The header can only be one which the proxy overwrites or appends to, otherwise
+	// clients could choose the address which they are identified by.
+	cfg.limiter.proxyHeader = "X-Forwarded-For"
+	flag.Func("limiter-trusted-proxy-header", "Header set by the trusted proxy (X-Forwarded-For|X-Real-IP)", func(s string) error {
+		header := http.CanonicalHeaderKey(s)
+		if header != "X-Forwarded-For" && header != "X-Real-Ip" {
+			return errors.New("must be X-Forwarded-For or X-Real-IP")
+		}
+		cfg.limiter.proxyHeader = header
+		return nil
+	})
+
+	// Read the SMTP server configuration settings into the config struct. The default
+	// values match a local MailHog instance, see docker-compose.yml.
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 1025, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("GREENLIGHT_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("GREENLIGHT_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.mrojasb2000.net>", "SMTP sender")
+	flag.IntVar(&cfg.smtp.retries, "smtp-retries", 3, "SMTP retries for transient failures")
+
+	// Read the mailer backend settings. The file backend writes each email to a .eml
+	// file in the mailer-dir directory rather than sending it.
+	flag.StringVar(&cfg.mailer.backend, "mailer", "smtp", "Mailer backend (smtp|file)")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "./tmp/mail", "Directory for the file mailer backend")
+
+	// Read the settings for the metrics listener. Setting -metrics-port to 0 disables
+	// it, and basic authentication is only required when a username is provided.
+	flag.IntVar(&cfg.metrics.port, "metrics-port", 4001, "Metrics server port (0 to disable)")
+	flag.StringVar(&cfg.metrics.username, "metrics-username", os.Getenv("GREENLIGHT_METRICS_USERNAME"), "Metrics basic auth username")
+	flag.StringVar(&cfg.metrics.password, "metrics-password", os.Getenv("GREENLIGHT_METRICS_PASSWORD"), "Metrics basic auth password")
+
+	// Read the settings for the GET /v1/healthcheck/ready readiness checks.
+	flag.DurationVar(&cfg.healthcheck.timeout, "healthcheck-timeout", 2*time.Second, "Timeout for the readiness checks")
+	flag.IntVar(&cfg.healthcheck.migrationVersion, "db-migration-version", 0, "Expected database schema migration version (0 for the latest embedded migration)")
+
+	// Read the retention period for soft-deleted movies, which is used by the "purge"
+	// command. The default is 30 days.
+	flag.DurationVar(&cfg.purge.retention, "purge-retention", 30*24*time.Hour, "How long to keep deleted movies before purging them")
+
+	// Read whether PATCH and DELETE requests for movies must include an If-Match header,
+	// so that clients can't accidentally overwrite changes they haven't seen.
+	flag.BoolVar(&cfg.conditional.requireIfMatch, "require-if-match", false, "Require an If-Match header on movie writes")
+
+	// Read the settings for the GET /debug/vars endpoint. Each entry in
+	// -debug-vars-allowed-ips can either be a single IP address or a CIDR network.
+	flag.BoolVar(&cfg.debug.vars, "debug-vars", false, "Expose /debug/vars to all clients")
+	flag.Func("debug-vars-allowed-ips", "IPs or CIDR networks allowed to access /debug/vars (space separated)", func(val string) error {
+		networks, err := parseNetworks(strings.Fields(val))
+		if err != nil {
+			return err
+		}
+		cfg.debug.allowedIPs = networks
+		return nil
+	})
+
+	// Use the flag.Func() function to process the -cors-trusted-origins command line
+	// flag. In this we use the strings.Fields() function to split the flag value into a
+	// slice based on whitespace characters and assign it to our config struct.
+	// Importantly, if the -cors-trusted-origins flag is not present, contains the empty
+	// string, or contains only whitespace, then strings.Fields() will return an empty
+	// []string slice.
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	// Create a new version boolean flag with the default value of false.
+	displayVersion := flag.Bool("version", false, "Display version and exit")
+
 	flag.Parse()
 
-	// Initialize a new logger which writes messages to the standard out stream
-	// prefixed with the current date and time
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	// If the version flag value is true, then print out the version number and build
+	// information and immediately exit.
+	if *displayVersion {
+		fmt.Printf("Version:\t%s\n", version)
+		fmt.Printf("Build commit:\t%s\n", buildCommit)
+		fmt.Printf("Build time:\t%s\n", buildTime)
+		os.Exit(0)
+	}
+
+	// Initialize a new jsonlog.Logger which writes any messages *at or above* the
+	// configured minimum severity level to the standard out stream.
+	logger := jsonlog.New(os.Stdout, cfg.logLevel)
+
+	// Check the rate limiter settings. With a rate or burst of zero no request would
+	// ever be allowed, and the limiter would tell clients to wait forever.
+	if cfg.limiter.enabled && (cfg.limiter.rps <= 0 || cfg.limiter.burst < 1) {
+		logger.PrintFatal(errors.New("-limiter-rps must be greater than 0 and -limiter-burst at least 1"), nil)
+	}
+	if cfg.limiter.trustedProxy && cfg.limiter.proxyHops < 1 {
+		logger.PrintFatal(errors.New("-limiter-trusted-proxy-hops must be at least 1"), nil)
+	}
 
 	// Call the openDB() helper function (see below) to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately.
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		logger.PrintFatal(err, nil)
 	}
 
-	// Defer a call to db.Close() so that the connection pool is closed before the
-	// main() function exit.
-	defer db.Close()
-
 	// Also log a message to say the connection pool has been successfully
 	// established.
-	logger.Printf("database connection pool established")
+	logger.PrintInfo("database connection pool established", nil)
+
+	// Initialize the migrator using the SQL migration files embedded in the binary.
+	migrator, err := migrate.New(db, migrations.FS, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// If the application was started with a subcommand, for example "api migrate up"
+	// or "api purge", then run it and exit instead of starting the server.
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			err = runMigrateCommand(migrator, logger, flag.Args()[1:])
+		case "purge":
+			err = runPurgeCommand(data.NewModels(db, cfg.db.queryTimeout), logger, cfg.purge.retention)
+		default:
+			err = fmt.Errorf("unknown command %q", flag.Arg(0))
+		}
+
+		db.Close()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		os.Exit(0)
+	}
+
+	// Apply any pending migrations at startup if the -db-automigrate flag is set. The
+	// migrator holds an advisory lock while it runs, so it's safe for several
+	// instances of the application to start at the same time.
+	if cfg.db.automigrate {
+		err = migrator.Up(context.Background(), 0)
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	// The readiness check expects the latest embedded migration to have been applied,
+	// unless a specific version was set with the -db-migration-version flag.
+	if cfg.healthcheck.migrationVersion == 0 {
+		cfg.healthcheck.migrationVersion = migrator.Latest()
+	}
+
+	// Publish the application version and build information, the number of active
+	// goroutines, the current timestamp and the database connection pool statistics
+	// as expvar variables, which are served by the GET /debug/vars endpoint. Notice
+	// that expvar.Func values are evaluated each time the endpoint is requested.
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("build", expvar.Func(func() interface{} {
+		return map[string]string{
+			"commit": buildCommit,
+			"time":   buildTime,
+		}
+	}))
+
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("timestamp", expvar.Func(func() interface{} {
+		return time.Now().Unix()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() interface{} {
+		return db.Stats()
+	}))
+
+	// Initialize the mailer using the configured backend.
+	emailer, err := openMailer(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
 	// Declare an instance of the application struct, containing the config struct and the logger
 	// Use the data.NewModels() function to initialize a Models struct, passing in the
 	// connection pool as a parameter.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:  cfg,
+		logger:  logger,
+		db:      db,
+		models:  data.NewModels(db, cfg.db.queryTimeout),
+		mailer:  emailer,
+		metrics: metrics.New(),
+		done:    make(chan struct{}),
 	}
 
-	// Use the httprouter instance returned by app.routes() as the server handler
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	// Call app.serve() to start the server, which blocks until it has been shut down
+	// gracefully.
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
-	// Start the HTTP server
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
+	// Only close the connection pool once the server has stopped and every background
+	// task has completed, as they may still need to use the database.
+	logger.PrintInfo("closing database connection pool", nil)
+	err = db.Close()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 }
 
 // The openDB function returns a sql.DB connection pool.
@@ -150,3 +451,49 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Return the sql.Db connection pool
 	return db, nil
 }
+
+// The openMailer function returns the mailer.Mailer implementation for the backend
+// selected in the config struct.
+func openMailer(cfg config) (mailer.Mailer, error) {
+	switch cfg.mailer.backend {
+	case "smtp":
+		return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender, cfg.smtp.retries), nil
+	case "file":
+		return mailer.NewFile(cfg.mailer.dir, cfg.smtp.sender)
+	default:
+		return nil, fmt.Errorf("unknown mailer backend %q", cfg.mailer.backend)
+	}
+}
+
+// The parseNetworks function converts a list of IP addresses and CIDR networks into a
+// slice of *net.IPNet. A single IP address is treated as a network containing only
+// that address.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
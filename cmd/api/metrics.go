@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mrojasb2000/greenlight/internal/metrics"
+)
+
+// The metricsRoutes() method returns the handler for the separate metrics listener,
+// which only serves the GET /metrics endpoint. If a metrics username has been
+// configured, then scrapers must authenticate with HTTP basic authentication.
+func (app *application) metricsRoutes() http.Handler {
+	router := httprouter.New()
+
+	router.HandlerFunc(http.MethodGet, "/metrics", app.metricsHandler)
+
+	if app.config.metrics.username == "" {
+		return router
+	}
+
+	return app.requireMetricsAuth(router)
+}
+
+// The metricsHandler() writes the HTTP request metrics, the statistics for the
+// database connection pool and the Go runtime statistics in the Prometheus text
+// exposition format.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+
+	app.metrics.Write(&buf)
+	if app.db != nil {
+		metrics.WriteDBStats(&buf, app.db.Stats())
+	}
+	metrics.WriteRuntimeStats(&buf)
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Write(buf.Bytes())
+}
+
+// The requireMetricsAuth() middleware checks the basic authentication credentials
+// against the configured metrics username and password. We use
+// subtle.ConstantTimeCompare() so that the comparison doesn't leak timing information.
+func (app *application) requireMetricsAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+
+		usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(app.config.metrics.username)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(app.config.metrics.password)) == 1
+
+		if !ok || !usernameMatch || !passwordMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The routePattern() function returns the route pattern in the router which matches
+// the request, like "/v1/movies/:id". The version of httprouter we use doesn't
+// record the matched pattern, so we look up the route and then find which path
+// segments hold the parameters, by replacing each segment in turn with a placeholder
+// and checking whether a parameter takes its value. Comparing the segments with the
+// parameter values instead would go wrong for paths like "/v1/movies/v1", where a
+// static segment has the same value as a parameter. Requests which don't match any
+// route are grouped together, so that clients requesting random URLs can't create an
+// unbounded number of series.
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	pattern := make([]string, len(segments))
+	copy(pattern, segments)
+
+	const placeholder = "\x00"
+
+	for i := 1; i < len(segments) && len(params) > 0; i++ {
+		original := segments[i]
+		segments[i] = placeholder
+		_, replaced, _ := router.Lookup(r.Method, strings.Join(segments, "/"))
+		segments[i] = original
+
+		for _, p := range replaced {
+			if p.Value == placeholder && params.ByName(p.Key) == original {
+				pattern[i] = ":" + p.Key
+				break
+			}
+		}
+	}
+
+	return strings.Join(pattern, "/")
+}
+
+// The methodLabel() function returns the request method for use as a metrics label.
+// Clients can send any method they like, so methods other than the standard ones are
+// grouped together as "other", for the same reason as the unmatched routes.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
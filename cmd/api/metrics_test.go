@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRoutePattern(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/v1/movies", handler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", handler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", handler)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   string
+	}{
+		{"Static", http.MethodGet, "/v1/movies", "/v1/movies"},
+		{"Parameter", http.MethodGet, "/v1/movies/12", "/v1/movies/:id"},
+		{"Parameter equal to a static segment", http.MethodGet, "/v1/movies/v1", "/v1/movies/:id"},
+		{"Two parameters", http.MethodGet, "/v1/movies/3/revisions/3", "/v1/movies/:id/revisions/:version"},
+		{"Parameter equal to a later segment", http.MethodGet, "/v1/movies/revisions/revisions/1", "/v1/movies/:id/revisions/:version"},
+		{"Unmatched", http.MethodGet, "/v1/no-such-route", "unmatched"},
+		{"Unmatched method", "FOO", "/v1/movies", "unmatched"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+
+			if got := routePattern(router, r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsMethodLabel(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	for _, method := range []string{"FOO1", "FOO2"} {
+		ts.do(t, method, "/v1/movies", "", "")
+	}
+
+	metricsServer := newTestServer(t, app.metricsRoutes())
+
+	_, _, body := metricsServer.do(t, http.MethodGet, "/metrics", "", "")
+	if strings.Contains(body, "FOO") {
+		t.Errorf("got metrics containing invented methods: %s", body)
+	}
+	if want := `method="other"`; !strings.Contains(body, want) {
+		t.Errorf("got metrics without %q", want)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/mrojasb2000/greenlight/internal/jsonlog"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+
+	rr := httptest.NewRecorder()
+	app.recoverPanic(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if got := rr.Header().Get("Connection"); got != "close" {
+		t.Errorf("got Connection header %q; want %q", got, "close")
+	}
+	if want := "the server encontered a problem"; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("got body %q; want it to contain %q", rr.Body.String(), want)
+	}
+}
+
+func TestRecoverPanicLogsUser(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.logger = jsonlog.New(&buf, jsonlog.LevelError)
+
+	user, token := newTestUser(t, app, "alice@example.com", true)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+
+	// The recoverPanic() middleware runs before authenticate(), as in routes().
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	app.requestID(app.recoverPanic(app.authenticate(next))).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if want := fmt.Sprintf(`"user_id":"%d"`, user.ID); !strings.Contains(buf.String(), want) {
+		t.Errorf("got log entry %q; want it to contain %q", buf.String(), want)
+	}
+}
+
+func TestServerErrorResponseCanceled(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		canceled bool
+		wantCode int
+	}{
+		{"Error", errors.New("oops"), false, http.StatusInternalServerError},
+		{"Error after client went away", errors.New("oops"), true, http.StatusInternalServerError},
+		{"Context canceled", fmt.Errorf("query: %w", context.Canceled), true, statusClientClosedRequest},
+		{"Other context canceled", fmt.Errorf("query: %w", context.Canceled), false, http.StatusInternalServerError},
+		{"Query canceled", &pq.Error{Code: "57014"}, true, statusClientClosedRequest},
+		{"Query timeout", &pq.Error{Code: "57014"}, false, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.canceled {
+				cancel()
+			} else {
+				defer cancel()
+			}
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+			app.serverErrorResponse(rr, r, tt.err)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 1
+
+	ts := newTestServer(t, app.routes())
+
+	code, _, _ := ts.do(t, http.MethodGet, "/v1/healthcheck", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("first request: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, header, _ := ts.do(t, http.MethodGet, "/v1/healthcheck", "", "")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("second request: got status %d; want %d", code, http.StatusTooManyRequests)
+	}
+	if header.Get("Retry-After") == "" {
+		t.Error("second request: got no Retry-After header")
+	}
+}
+
+func TestRateLimitNeverAllowed(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 0
+
+	ts := newTestServer(t, app.routes())
+
+	code, header, _ := ts.do(t, http.MethodGet, "/v1/healthcheck", "", "")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("got status %d; want %d", code, http.StatusTooManyRequests)
+	}
+	if got := header.Get("Retry-After"); got != "" {
+		t.Errorf("got Retry-After header %q; want none", got)
+	}
+}
+
+func TestRateLimitSpoofedForwardedFor(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 1
+	app.config.limiter.burst = 1
+	app.config.limiter.trustedProxy = true
+	app.config.limiter.proxyHeader = "X-Forwarded-For"
+	app.config.limiter.proxyHops = 1
+
+	ts := newTestServer(t, app.routes())
+
+	// The client sends a different address each time, and the proxy appends the
+	// address that the request really came from.
+	for i, fwd := range []string{"198.51.100.1, 203.0.113.7", "198.51.100.2, 203.0.113.7"} {
+		code, _, _ := ts.doWithHeaders(t, http.MethodGet, "/v1/healthcheck", "", "", http.Header{"X-Forwarded-For": {fwd}})
+
+		want := http.StatusOK
+		if i > 0 {
+			want = http.StatusTooManyRequests
+		}
+		if code != want {
+			t.Errorf("request %d: got status %d; want %d", i+1, code, want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted bool
+		header  string
+		hops    int
+		headers http.Header
+		want    string
+	}{
+		{"Untrusted", false, "X-Forwarded-For", 1, http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Real-Ip": {"203.0.113.7"}}, "192.0.2.1"},
+		{"Forwarded", true, "X-Forwarded-For", 1, http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"Forwarded spoofed", true, "X-Forwarded-For", 1, http.Header{"X-Forwarded-For": {"127.0.0.1, 203.0.113.7"}}, "203.0.113.7"},
+		{"Forwarded on several lines", true, "X-Forwarded-For", 1, http.Header{"X-Forwarded-For": {"127.0.0.1", "203.0.113.7"}}, "203.0.113.7"},
+		{"Forwarded two hops", true, "X-Forwarded-For", 2, http.Header{"X-Forwarded-For": {"127.0.0.1, 203.0.113.7, 10.0.0.2"}}, "203.0.113.7"},
+		{"Forwarded too few hops", true, "X-Forwarded-For", 2, http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "192.0.2.1"},
+		{"Forwarded ignores X-Real-IP", true, "X-Forwarded-For", 1, http.Header{"X-Real-Ip": {"203.0.113.7"}}, "192.0.2.1"},
+		{"Real IP", true, "X-Real-Ip", 1, http.Header{"X-Real-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"127.0.0.1"}}, "203.0.113.7"},
+		{"Invalid", true, "X-Forwarded-For", 1, http.Header{"X-Forwarded-For": {"not-an-ip"}}, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.limiter.trustedProxy = tt.trusted
+			app.config.limiter.proxyHeader = tt.header
+			app.config.limiter.proxyHops = tt.hops
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for key, values := range tt.headers {
+				r.Header[key] = values
+			}
+
+			got, err := app.clientIP(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		wantCode    int
+		wantOrigin  string
+		wantMethods bool
+	}{
+		{"Trusted origin", http.MethodGet, "https://www.example.com", http.StatusOK, "https://www.example.com", false},
+		{"Untrusted origin", http.MethodGet, "https://evil.example.com", http.StatusOK, "", false},
+		{"Preflight", http.MethodOptions, "https://www.example.com", http.StatusOK, "https://www.example.com", true},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+"/v1/healthcheck", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", tt.origin)
+		if tt.method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+		}
+
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+
+		if rs.StatusCode != tt.wantCode {
+			t.Errorf("%s: got status %d; want %d", tt.name, rs.StatusCode, tt.wantCode)
+		}
+		if got := rs.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q; want %q", tt.name, got, tt.wantOrigin)
+		}
+		if got := rs.Header.Get("Access-Control-Allow-Methods"); tt.wantMethods != strings.Contains(got, http.MethodPatch) {
+			t.Errorf("%s: got Access-Control-Allow-Methods %q", tt.name, got)
+		}
+		if got := strings.Join(rs.Header.Values("Vary"), ", "); !strings.Contains(got, "Origin") {
+			t.Errorf("%s: got Vary header %q; want it to contain Origin", tt.name, got)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, header, _ := ts.do(t, http.MethodGet, "/v1/healthcheck", "", "")
+	if got := header.Get("X-Request-ID"); len(got) != 32 {
+		t.Errorf("got generated X-Request-ID %q; want 32 hex characters", got)
+	}
+
+	for _, tt := range []struct {
+		sent string
+		keep bool
+	}{
+		{"abc-123", true},
+		{"bad id\r\n", false},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/healthcheck", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header["X-Request-Id"] = []string{tt.sent}
+
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			// The client refuses to send some invalid header values, which is fine.
+			continue
+		}
+		rs.Body.Close()
+
+		if got := rs.Header.Get("X-Request-ID"); (got == tt.sent) != tt.keep {
+			t.Errorf("sent X-Request-ID %q: got %q", tt.sent, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mrojasb2000/greenlight/internal/jsonlog"
+	"github.com/mrojasb2000/greenlight/internal/migrate"
+)
+
+// The runMigrateCommand function handles the "migrate" subcommand, which applies the
+// migrations embedded in the binary to the database instead of starting the server.
+// The args are the command line arguments following "migrate":
+//
+//	migrate up [N]     apply all (or the next N) pending migrations
+//	migrate down N     roll back the last N migrations
+//	migrate version    print the current schema version
+//	migrate force N    set the schema version and clear the dirty flag
+func runMigrateCommand(migrator *migrate.Migrator, logger *jsonlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing migrate command (up|down N|version|force N)")
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n := 0
+		if len(args) > 1 {
+			var err error
+			n, err = parseMigrateArg(args[1])
+			if err != nil {
+				return err
+			}
+		}
+
+		err := migrator.Up(ctx, n)
+		if errors.Is(err, migrate.ErrNoChange) {
+			logger.PrintInfo("no migrations to apply", nil)
+			return nil
+		}
+		return err
+
+	case "down":
+		if len(args) < 2 {
+			return errors.New("migrate down requires the number of migrations to roll back")
+		}
+
+		n, err := parseMigrateArg(args[1])
+		if err != nil {
+			return err
+		}
+
+		err = migrator.Down(ctx, n)
+		if errors.Is(err, migrate.ErrNoChange) {
+			logger.PrintInfo("no migrations to roll back", nil)
+			return nil
+		}
+		return err
+
+	case "version":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Version:\t%d\n", version)
+		fmt.Printf("Dirty:\t\t%t\n", dirty)
+		fmt.Printf("Latest:\t\t%d\n", migrator.Latest())
+		return nil
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New("migrate force requires a version")
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+
+		err = migrator.Force(ctx, version)
+		if err != nil {
+			return err
+		}
+
+		logger.PrintInfo("forced schema version", map[string]string{"version": args[1]})
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// The parseMigrateArg function parses the number of migrations to apply or roll back,
+// which must be a positive integer.
+func parseMigrateArg(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid number of migrations %q", s)
+	}
+
+	return n, nil
+}
@@ -4,9 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/mrojasb2000/greenlight/internal/data"
+	"github.com/mrojasb2000/greenlight/internal/jsonpatch"
 	"github.com/mrojasb2000/greenlight/internal/validator"
 )
 
@@ -57,8 +58,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Call the Insert() method on our movies model, passing in a pointer to the
 	// validated movie struct. This will create a record in the database and update the
-	// movie struct with the system-generated information.
-	err = app.models.Movies.Insert(movie)
+	// movie struct with the system-generated information. The context carries the
+	// current user and client, which are recorded in the movie's first revision.
+	err = app.models.Movies.Insert(app.contextWithActor(r), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -93,7 +95,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data. ErrRecordNotFound
 	// error, in which case we send a 404 Not Found presnse to the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -103,12 +105,43 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+
+	// Send a 304 Not Modified response if the client already has the current version
+	// of the movie, as identified by the ETag it sent in the If-None-Match header.
+	tag := etag(movie.ID, movie.Version)
+	if !app.checkIfNoneMatch(w, r, tag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", tag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The movieDocument type holds the fields of a movie which clients can change. Patches
+// are applied to its JSON encoding, which is the same as the movie's JSON encoding
+// without the read-only id and version fields.
+type movieDocument struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// The copyTo() method copies the fields from the document to a movie.
+func (doc movieDocument) copyTo(movie *data.Movie) {
+	movie.Title = doc.Title
+	movie.Year = doc.Year
+	movie.Runtime = doc.Runtime
+	movie.Genres = doc.Genres
+}
+
+// Add an updateMovieHandler for the "PATCH /v1/movies/:id" endpoint, which supports
+// JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) documents.
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL.
 	id, err := app.readIDParam(r)
@@ -119,7 +152,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Fetch the existing movie record from the database, sending a 404 Not Found
 	// response to the client if we couldn't find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -130,61 +163,149 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// If the request contains a X-Expected-Version header, verify that the movie
-	// version in the database matches the expected version specified in the header.
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
-			return
-		}
+	// If the request contains an If-Match header, verify that it matches the ETag for
+	// the movie version in the database. If the movie has changed since the client
+	// fetched it, we send a 412 Precondition Failed response.
+	if !app.checkIfMatch(w, r, etag(movie.ID, movie.Version)) {
+		return
 	}
 
-	// Declare an input struct to hold the expected data from the client.
-	// Use pointers for the Title, Year and Runtime fields.
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	// Apply the patch in the request body to the fields of the movie which clients
+	// can change. The patch format depends on the Content-Type header: a JSON Merge
+	// Patch can set fields or remove them (by setting them to null), and a JSON Patch
+	// can also make changes inside the genres array, like appending a single genre.
+	doc := movieDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
 	}
-	// Read the JSON request body data into the input struct
-	err = app.readJSON(w, r, &input)
+
+	v := validator.New()
+
+	err = app.applyPatch(w, r, &doc, v)
 	if err != nil {
-		app.badResquestResponse(w, r, err)
+		switch {
+		case errors.Is(err, errUnsupportedPatchType):
+			app.unsupportedMediaTypeResponse(w, r)
+		case errors.Is(err, jsonpatch.ErrConflict):
+			app.patchConflictResponse(w, r, err)
+		default:
+			app.badResquestResponse(w, r, err)
+		}
 		return
 	}
-	// Copy the values from the request body to the appropiate fields of the movie
-	// record
-	// If the input.Title value is nil then we know that no corresponding "title" key/
-	// value pair was provided in the JSON request body. So we move on and leave the
-	// movie record unchanged. Otherwise, we update the movie record with the new title
-	// value. Importantly, becaause input.Title is a now a pointer to a string, we need
-	// to dereference the pointer usong the * operator to get the undelying value
-	// before assigning it to our movie record.
-	if input.Title != nil {
-		movie.Title = *input.Title
+
+	// Copy the patched fields to the movie record, and validate it, sending the client
+	// a 422 Unprocessable Entity response if any checks fail. Removing a field means it
+	// has its zero value, so the checks will report that it must be provided.
+	if v.Valid() {
+		doc.copyTo(movie)
+		data.ValidateMovie(v, movie)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	if input.Year != nil {
-		movie.Year = *input.Year
+
+	// Pass the updated movie record to our new Update() method.
+	// Intercept any ErrEditConflict error and call the new editConflictResponse()
+	// helper.
+	err = app.models.Movies.Update(app.contextWithActor(r), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Write the updated movie record in a JSON response, along with its new ETag.
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.ID, movie.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+}
+
+// Add a replaceMovieHandler for the "PUT /v1/movies/:id" endpoint. Unlike PATCH, the
+// request body must contain a complete movie, which replaces the stored one. The
+// If-Match header is checked in the same way as for PATCH. If the request has an
+// If-None-Match: * header instead, the movie is created with the ID in the URL, as
+// long as no movie with that ID exists, which lets clients create movies without
+// risking duplicates if they have to retry the request.
+func (app *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+
+	// Read the complete movie from the request body. The readJSON() helper rejects any
+	// unknown fields, including the read-only id and version fields.
+	var doc movieDocument
+
+	err = app.readJSON(w, r, &doc)
+	if err != nil {
+		app.badResquestResponse(w, r, err)
+		return
 	}
 
-	// Validate the updated movie record, sending the client a 422 Unprocessable Entity
-	// responsable if any checks fail.
+	// Every field is required, so validating the movie also checks that the body
+	// contains a complete representation.
+	movie := &data.Movie{ID: id}
+	doc.copyTo(movie)
+
 	v := validator.New()
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	// Pass the updated movie record to our new Update() method.
-	// Intercept any ErrEditConflict error and call the new editConflictResponse()
-	// helper.
-	err = app.models.Movies.Update(movie)
+
+	if r.Header.Get("If-None-Match") == "*" {
+		if data.ValidateClientMovieID(v, id); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		app.createMovieWithID(w, r, movie)
+		return
+	}
+
+	// Otherwise we are replacing an existing movie, so fetch it to check the
+	// preconditions against its current ETag.
+	existing, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tag := etag(existing.ID, existing.Version)
+
+	if !app.checkIfMatch(w, r, tag) {
+		return
+	}
+
+	// For requests other than GET and HEAD, an If-None-Match header which matches the
+	// current ETag means the precondition has failed (see RFC 7232 section 3.2).
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, tag, true) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// Replace the movie, using the version we checked the preconditions against for
+	// the optimistic locking check.
+	movie.Version = existing.Version
+
+	err = app.models.Movies.Update(app.contextWithActor(r), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -194,8 +315,37 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.ID, movie.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createMovieWithID() method handles a PUT request with an If-None-Match: * header,
+// by inserting the movie with the ID from the URL. If a movie with that ID already
+// exists (including one which has been deleted but not purged), the precondition has
+// failed and we send a 412 Precondition Failed response.
+func (app *application) createMovieWithID(w http.ResponseWriter, r *http.Request, movie *data.Movie) {
+	err := app.models.Movies.InsertWithID(app.contextWithActor(r), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateID):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", etag(movie.ID, movie.Version))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -209,9 +359,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database, sending a 404 Not Found response to the
-	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// Fetch the existing movie record from the database, sending a 404 Not Found
+	// response to the client if we couldn't find a matching record.
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -222,9 +372,129 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Check the If-Match precondition against the current ETag for the movie.
+	if !app.checkIfMatch(w, r, etag(movie.ID, movie.Version)) {
+		return
+	}
+
+	// Soft-delete the movie, passing in the version that we fetched (and checked the
+	// precondition against). If the movie has been changed by someone else in the
+	// meantime, we send a 409 Conflict response rather than deleting their changes.
+	err = app.models.Movies.Delete(app.contextWithActor(r), movie.ID, movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// Return 200 Ok status code along with a success message.
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Add a restoreMovieHandler for the "POST /v1/movies/:id/restore" endpoint, which
+// undoes the soft delete of a movie that hasn't been purged yet.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Restore the movie, sending a 404 Not Found response if there isn't a deleted
+	// movie with the ID.
+	movie, err := app.models.Movies.Restore(app.contextWithActor(r), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.ID, movie.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// To keep things consistent with our other handlers, we'll define an input struct
+	// to hold the expected values from the request query string.
+	var input struct {
+		Title  string
+		Genres []string
+		Search string
+		data.Filters
+	}
+
+	// Initialize a new Validator instance.
+	v := validator.New()
+
+	// Call r.URL.Query() to get the url.Values map containing the query string data.
+	qs := r.URL.Query()
+
+	// Use our helpers to extract the title and genres query string values, falling back
+	// to defaults of an empty string and an empty slice respectively if they are not
+	// provided by the client.
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+
+	// Keep only the searchable words from the q parameter. If the client sent a search
+	// which doesn't contain any, we send an error, rather than silently ignoring it and
+	// returning every movie.
+	search := app.readString(qs, "q", "")
+	input.Search = strings.Join(data.SearchWords(search), " ")
+	v.Check(strings.TrimSpace(search) == "" || input.Search != "", "q", "must contain at least one letter or digit")
+
+	// Get the page and page_size query string values as integers. Notice that we set
+	// the default page value to 1 and default page_size to 20, and that we pass the
+	// validator instance as the final argument here.
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Extract the sort query string value, falling back to "id" if it is not provided
+	// by the client (which will imply an ascending sort on movie ID). When the client
+	// is searching we fall back to ordering by relevance instead, with the best
+	// matches first.
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "-relevance"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+
+	// Add the supported sort values for this endpoint to the sort safelist.
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title", "-year", "-runtime", "-relevance"}
+
+	// Execute the validation checks on the Filters struct and send a response
+	// containing the errors if necessary.
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Call the GetAll() method to retrieve the movies, passing in the various filter
+	// parameters.
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Include the metadata in the response envelope.
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+)
+
+func TestCreateMovie(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+
+	code, header, body := ts.do(t, http.MethodPost, "/v1/movies", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`, writer)
+	if code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d (%s)", code, http.StatusCreated, body)
+	}
+
+	var created struct {
+		Movie struct {
+			ID      int64  `json:"id"`
+			Runtime string `json:"runtime"`
+			Version int32  `json:"version"`
+		} `json:"movie"`
+	}
+	decode(t, body, &created)
+
+	if created.Movie.ID < 1 || created.Movie.Version != 1 || created.Movie.Runtime != "107 mins" {
+		t.Errorf("got movie %+v", created.Movie)
+	}
+	if got, want := header.Get("Location"), fmt.Sprintf("/v1/movies/%d", created.Movie.ID); got != want {
+		t.Errorf("got Location %q; want %q", got, want)
+	}
+}
+
+func TestMovieHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+	_, reader := newTestUser(t, app, "reader@example.com", true, "movies:read")
+
+	// Each test case gets its own movie, which is deleted before the request is made if
+	// deleted is true. Any "{id}" in the URL is replaced with the ID of the movie.
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		body     string
+		token    string
+		deleted  bool
+		wantCode int
+		wantBody string
+	}{
+		{"Create unauthenticated", http.MethodPost, "/v1/movies", `{}`, "", false, http.StatusUnauthorized, "you must be authenticated"},
+		{"Create without permission", http.MethodPost, "/v1/movies", `{}`, reader, false, http.StatusForbidden, "necessary permissions"},
+		{"Create invalid", http.MethodPost, "/v1/movies", `{"title": ""}`, writer, false, http.StatusUnprocessableEntity, "must be provided"},
+		{"Create badly-formed JSON", http.MethodPost, "/v1/movies", `{"title": `, writer, false, http.StatusBadRequest, "badly-formed JSON"},
+		{"Show", http.MethodGet, "/v1/movies/{id}", "", reader, false, http.StatusOK, `"title":"Moana"`},
+		{"Show missing", http.MethodGet, "/v1/movies/999999", "", reader, false, http.StatusNotFound, "could not be found"},
+		{"Show invalid ID", http.MethodGet, "/v1/movies/foo", "", reader, false, http.StatusNotFound, "could not be found"},
+		{"Show deleted", http.MethodGet, "/v1/movies/{id}", "", reader, true, http.StatusNotFound, "could not be found"},
+		{"Update", http.MethodPatch, "/v1/movies/{id}", `{"year": 2017}`, writer, false, http.StatusOK, `"year":2017`},
+		{"Update invalid", http.MethodPatch, "/v1/movies/{id}", `{"year": 1500}`, writer, false, http.StatusUnprocessableEntity, "must be greater than 1888"},
+		{"Update missing", http.MethodPatch, "/v1/movies/999999", `{"year": 2017}`, writer, false, http.StatusNotFound, "could not be found"},
+		{"Update deleted", http.MethodPatch, "/v1/movies/{id}", `{"year": 2017}`, writer, true, http.StatusNotFound, "could not be found"},
+		{"Delete without permission", http.MethodDelete, "/v1/movies/{id}", "", reader, false, http.StatusForbidden, "necessary permissions"},
+		{"Delete", http.MethodDelete, "/v1/movies/{id}", "", writer, false, http.StatusOK, "movie successfully deleted"},
+		{"Delete deleted", http.MethodDelete, "/v1/movies/{id}", "", writer, true, http.StatusNotFound, "could not be found"},
+		{"Restore without permission", http.MethodPost, "/v1/movies/{id}/restore", "", reader, true, http.StatusForbidden, "necessary permissions"},
+		{"Restore", http.MethodPost, "/v1/movies/{id}/restore", "", writer, true, http.StatusOK, `"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation","adventure"],"version":3`},
+		{"Restore not deleted", http.MethodPost, "/v1/movies/{id}/restore", "", writer, false, http.StatusNotFound, "could not be found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie(t, app)
+
+			if tt.deleted {
+				err := app.models.Movies.Delete(context.Background(), movie.ID, movie.Version)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			code, _, body := ts.do(t, tt.method, withID(tt.urlPath, movie.ID), tt.body, tt.token)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestListMovies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, reader := newTestUser(t, app, "reader@example.com", true, "movies:read")
+
+	// The movies are only read by the test cases, so they can share them.
+	ctx := context.Background()
+
+	for _, movie := range []*data.Movie{
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		{Title: "Frozen", Year: 2013, Runtime: 102, Genres: []string{"animation", "musical"}},
+		{Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"crime"}},
+		{Title: "Coco", Year: 2017, Runtime: 105, Genres: []string{"animation"}},
+	} {
+		if err := app.models.Movies.Insert(ctx, movie); err != nil {
+			t.Fatal(err)
+		}
+		if movie.Title == "Coco" {
+			if err := app.models.Movies.Delete(ctx, movie.ID, movie.Version); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"All except deleted", "", http.StatusOK, `"total_records":3`},
+		{"Genre", "genres=animation", http.StatusOK, `"total_records":2`},
+		{"Several genres", "genres=animation,musical", http.StatusOK, `"total_records":1`},
+		{"Title", "title=frozen", http.StatusOK, `"title":"Frozen"`},
+		{"No match", "title=up", http.StatusOK, `"movies":[]`},
+		{"Sort and page", "sort=-year&page=2&page_size=1", http.StatusOK, `"title":"Frozen"`},
+		{"Invalid sort", "sort=foo", http.StatusUnprocessableEntity, "invalid sort value"},
+		{"Invalid page", "page=0", http.StatusUnprocessableEntity, `"page":"must be greater than zero"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, "/v1/movies?"+tt.query, "", reader)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestListMoviesSearch(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, reader := newTestUser(t, app, "reader@example.com", true, "movies:read")
+
+	for _, title := range []string{"Moana", "Frozen"} {
+		movie := &data.Movie{Title: title, Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+		if err := app.models.Movies.Insert(context.Background(), movie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{"Prefix", "q=moa", http.StatusOK, `"total_records":1`},
+		{"Punctuation around words", "q=%22moa%22!", http.StatusOK, `"total_records":1`},
+		{"Only punctuation", "q=%22!%2A%22", http.StatusUnprocessableEntity, `"q":"must contain at least one letter or digit"`},
+		{"Empty", "q=", http.StatusOK, `"total_records":2`},
+		{"Only punctuation with sort", "q=!!&sort=title", http.StatusUnprocessableEntity, `"q":"must contain at least one letter or digit"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, "/v1/movies?"+tt.query, "", reader)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMovieConditionalRequests(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+
+	// Each test case gets its own movie at version 1. Any "{id}" in the headers or the
+	// expected ETag is replaced with the ID of the movie.
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		headers  http.Header
+		wantCode int
+		wantETag string
+	}{
+		{"Show", http.MethodGet, "", nil, http.StatusOK, `"{id}-1"`},
+		{"Show not modified", http.MethodGet, "", http.Header{"If-None-Match": {`"{id}-1"`}}, http.StatusNotModified, `"{id}-1"`},
+		{"Show weak not modified", http.MethodGet, "", http.Header{"If-None-Match": {`"0-9", W/"{id}-1"`}}, http.StatusNotModified, `"{id}-1"`},
+		{"Show modified", http.MethodGet, "", http.Header{"If-None-Match": {`"{id}-0"`}}, http.StatusOK, `"{id}-1"`},
+		{"Update stale", http.MethodPatch, `{"year": 2017}`, http.Header{"If-Match": {`"{id}-0"`}}, http.StatusPreconditionFailed, ""},
+		{"Update weak", http.MethodPatch, `{"year": 2017}`, http.Header{"If-Match": {`W/"{id}-1"`}}, http.StatusPreconditionFailed, ""},
+		{"Update", http.MethodPatch, `{"year": 2017}`, http.Header{"If-Match": {`"{id}-1"`}}, http.StatusOK, `"{id}-2"`},
+		{"Update wildcard", http.MethodPatch, `{"year": 2018}`, http.Header{"If-Match": {"*"}}, http.StatusOK, `"{id}-2"`},
+		{"Delete stale", http.MethodDelete, "", http.Header{"If-Match": {`"{id}-2"`}}, http.StatusPreconditionFailed, ""},
+		{"Delete", http.MethodDelete, "", http.Header{"If-Match": {`"{id}-1"`}}, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie(t, app)
+
+			code, header, body := ts.doWithHeaders(t, tt.method, withID("/v1/movies/{id}", movie.ID), tt.body, writer, headersWithID(tt.headers, movie.ID))
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if got, want := header.Get("ETag"), withID(tt.wantETag, movie.ID); got != want {
+				t.Errorf("got ETag %q; want %q", got, want)
+			}
+			if code == http.StatusNotModified && body != "" {
+				t.Errorf("got body %q; want no body", body)
+			}
+		})
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.conditional.requireIfMatch = true
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		headers  http.Header
+		wantCode int
+	}{
+		{"PATCH", http.MethodPatch, `{"year": 2017}`, nil, http.StatusPreconditionRequired},
+		{"PUT", http.MethodPut, `{"title": "Moana", "year": 2017, "runtime": "107 mins", "genres": ["animation"]}`, nil, http.StatusPreconditionRequired},
+		{"DELETE", http.MethodDelete, "", nil, http.StatusPreconditionRequired},
+		{"PATCH with If-Match", http.MethodPatch, `{"year": 2017}`, http.Header{"If-Match": {`"{id}-1"`}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie(t, app)
+
+			code, _, body := ts.doWithHeaders(t, tt.method, withID("/v1/movies/{id}", movie.ID), tt.body, writer, headersWithID(tt.headers, movie.ID))
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+		})
+	}
+}
+
+func TestMoviePatchFormats(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+
+	mergePatch := http.Header{"Content-Type": {"application/merge-patch+json"}}
+	jsonPatch := http.Header{"Content-Type": {"application/json-patch+json; charset=utf-8"}}
+
+	// Each test case patches its own movie, which starts with the genres "animation"
+	// and "adventure".
+	tests := []struct {
+		name     string
+		body     string
+		headers  http.Header
+		wantCode int
+		wantBody string
+	}{
+		{"Merge patch", `{"title": "Moana 2"}`, mergePatch, http.StatusOK, `"title":"Moana 2"`},
+		{"Merge patch as application/json", `{"year": 2015}`, http.Header{"Content-Type": {"application/json"}}, http.StatusOK, `"year":2015`},
+		{"Merge patch remove field", `{"runtime": null}`, mergePatch, http.StatusUnprocessableEntity, `"runtime":"must be provided"`},
+		{"Merge patch unknown field", `{"rating": 5}`, mergePatch, http.StatusUnprocessableEntity, `"rating":"is not a known field"`},
+		{"Merge patch wrong type", `{"year": "2016"}`, mergePatch, http.StatusUnprocessableEntity, `"year":"must be an integer"`},
+		{"Merge patch read-only field", `{"version": 10}`, mergePatch, http.StatusUnprocessableEntity, `"version":"is not a known field"`},
+		{"JSON patch append genre", `[{"op": "add", "path": "/genres/-", "value": "musical"}]`, jsonPatch, http.StatusOK, `"genres":["animation","adventure","musical"]`},
+		{"JSON patch test and remove", `[{"op": "test", "path": "/genres/0", "value": "animation"}, {"op": "remove", "path": "/genres/0"}]`, jsonPatch, http.StatusOK, `"genres":["adventure"]`},
+		{"JSON patch move", `[{"op": "move", "from": "/genres/1", "path": "/genres/0"}]`, jsonPatch, http.StatusOK, `"genres":["adventure","animation"]`},
+		{"JSON patch duplicate genre", `[{"op": "copy", "from": "/genres/0", "path": "/genres/-"}]`, jsonPatch, http.StatusUnprocessableEntity, "must not contain duplicate values"},
+		{"JSON patch runtime", `[{"op": "replace", "path": "/runtime", "value": "110 mins"}]`, jsonPatch, http.StatusOK, `"runtime":"110 mins"`},
+		{"JSON patch invalid runtime", `[{"op": "replace", "path": "/runtime", "value": 110}]`, jsonPatch, http.StatusUnprocessableEntity, `"runtime":"must be in the format`},
+		{"JSON patch failed test", `[{"op": "test", "path": "/title", "value": "Frozen"}]`, jsonPatch, http.StatusConflict, "test failed"},
+		{"JSON patch missing path", `[{"op": "remove", "path": "/genres/5"}]`, jsonPatch, http.StatusConflict, "patch cannot be applied"},
+		{"JSON patch malformed", `{"op": "remove", "path": "/title"}`, jsonPatch, http.StatusBadRequest, "must be an array"},
+		{"JSON patch as application/json", `[{"op": "remove", "path": "/genres/0"}]`, http.Header{"Content-Type": {"application/json"}}, http.StatusUnsupportedMediaType, "application/json-patch+json"},
+		{"JSON patch without Content-Type", `[{"op": "remove", "path": "/genres/0"}]`, nil, http.StatusUnsupportedMediaType, "application/json-patch+json"},
+		{"Unsupported media type", `title=Frozen`, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, http.StatusUnsupportedMediaType, "application/merge-patch+json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie(t, app)
+
+			code, header, body := ts.doWithHeaders(t, http.MethodPatch, withID("/v1/movies/{id}", movie.ID), tt.body, writer, tt.headers)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+			if code == http.StatusUnsupportedMediaType && header.Get("Accept-Patch") == "" {
+				t.Error("missing Accept-Patch header")
+			}
+		})
+	}
+}
+
+func TestReplaceMovie(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+
+	frozen := `{"title": "Frozen", "year": 2013, "runtime": "102 mins", "genres": ["animation", "musical"]}`
+	createOnly := http.Header{"If-None-Match": {"*"}}
+
+	// Each test case gets its own movie at version 1. Any "{id}" in the URL, headers or
+	// expected ETag is replaced with the ID of the movie.
+	tests := []struct {
+		name     string
+		urlPath  string
+		body     string
+		headers  http.Header
+		wantCode int
+		wantETag string
+		wantBody string
+	}{
+		{"Replace", "/v1/movies/{id}", frozen, nil, http.StatusOK, `"{id}-2"`, `"title":"Frozen","year":2013,"runtime":"102 mins","genres":["animation","musical"],"version":2`},
+		{"Replace missing field", "/v1/movies/{id}", `{"title": "Frozen", "year": 2013, "genres": ["animation"]}`, nil, http.StatusUnprocessableEntity, "", `"runtime":"must be provided"`},
+		{"Replace read-only field", "/v1/movies/{id}", `{"title": "Frozen", "year": 2013, "runtime": "102 mins", "genres": ["animation"], "version": 9}`, nil, http.StatusBadRequest, "", `unknown field \"version\"`},
+		{"Replace stale", "/v1/movies/{id}", frozen, http.Header{"If-Match": {`"{id}-0"`}}, http.StatusPreconditionFailed, "", ""},
+		{"Replace current", "/v1/movies/{id}", frozen, http.Header{"If-Match": {`"{id}-1"`}}, http.StatusOK, `"{id}-2"`, `"version":2`},
+		{"Replace unchanged", "/v1/movies/{id}", frozen, http.Header{"If-None-Match": {`"{id}-1"`}}, http.StatusPreconditionFailed, "", ""},
+		{"Replace missing", "/v1/movies/999999", frozen, nil, http.StatusNotFound, "", ""},
+		{"Create existing", "/v1/movies/{id}", frozen, createOnly, http.StatusPreconditionFailed, "", ""},
+		{"Create invalid", "/v1/movies/999999", `{"title": "Frozen"}`, createOnly, http.StatusUnprocessableEntity, "", `"year":"must be provided"`},
+		{"Create with too large id", "/v1/movies/9223372036854775807", frozen, createOnly, http.StatusUnprocessableEntity, "", `"id":"must not be greater than 2147483647"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie(t, app)
+
+			code, header, body := ts.doWithHeaders(t, http.MethodPut, withID(tt.urlPath, movie.ID), tt.body, writer, headersWithID(tt.headers, movie.ID))
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if got, want := header.Get("ETag"), withID(tt.wantETag, movie.ID); got != want {
+				t.Errorf("got ETag %q; want %q", got, want)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// Creating a movie with PUT is tested as a sequence of requests, because what's being
+// tested is how the requests which follow the first one behave.
+func TestReplaceMovieCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+
+	frozen := `{"title": "Frozen", "year": 2013, "runtime": "102 mins", "genres": ["animation", "musical"]}`
+	createOnly := http.Header{"If-None-Match": {"*"}}
+
+	code, header, body := ts.doWithHeaders(t, http.MethodPut, "/v1/movies/5", frozen, writer, createOnly)
+	if code != http.StatusCreated || header.Get("ETag") != `"5-1"` || header.Get("Location") != "/v1/movies/5" {
+		t.Fatalf("create: got status %d, ETag %q and Location %q (%s)", code, header.Get("ETag"), header.Get("Location"), body)
+	}
+
+	// Retrying the request doesn't create a second movie.
+	code, _, body = ts.doWithHeaders(t, http.MethodPut, "/v1/movies/5", frozen, writer, createOnly)
+	if code != http.StatusPreconditionFailed {
+		t.Errorf("retry: got status %d; want %d (%s)", code, http.StatusPreconditionFailed, body)
+	}
+
+	// Movies created with POST carry on after the highest ID chosen by a client.
+	code, header, body = ts.do(t, http.MethodPost, "/v1/movies", frozen, writer)
+	if code != http.StatusCreated || header.Get("Location") != "/v1/movies/6" {
+		t.Errorf("create with POST: got status %d and Location %q; want %d and %q (%s)", code, header.Get("Location"), http.StatusCreated, "/v1/movies/6", body)
+	}
+}
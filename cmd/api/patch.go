@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+	"github.com/mrojasb2000/greenlight/internal/jsonpatch"
+	"github.com/mrojasb2000/greenlight/internal/validator"
+)
+
+// Define constants for the media types of the patch formats supported by our PATCH
+// endpoints. The acceptPatch value is sent in the Accept-Patch header.
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+	acceptPatch         = mergePatchMediaType + ", " + jsonPatchMediaType
+)
+
+// The errUnsupportedPatchType error is returned by applyPatch() when the request has a
+// Content-Type which isn't one of the supported patch formats.
+var errUnsupportedPatchType = errors.New("unsupported patch media type")
+
+// The applyPatch() helper reads the patch in the request body and applies it to the
+// JSON encoding of doc, choosing the patch format based on the Content-Type header.
+// Requests with the application/json type, or no type at all, are treated as a JSON
+// Merge Patch, which is how clients have always sent partial updates. But a JSON Patch
+// sent with one of those types is rejected as unsupported, rather than being applied
+// as a merge patch (which would try to replace the whole record with an array). The
+// result is
+// decoded back into doc, and any problems with it (like unknown fields, or values of
+// the wrong type) are recorded in v.
+//
+// The error returned wraps errUnsupportedPatchType, jsonpatch.ErrInvalidPatch or
+// jsonpatch.ErrConflict, or is an error from reading the request body.
+func (app *application) applyPatch(w http.ResponseWriter, r *http.Request, doc interface{}, v *validator.Validator) error {
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedPatchType
+		}
+	}
+
+	var patchFunc func(doc, patch []byte) ([]byte, error)
+
+	switch mediaType {
+	case mergePatchMediaType, "application/json", "":
+		patchFunc = jsonpatch.MergePatch
+	case jsonPatchMediaType:
+		patchFunc = jsonpatch.Apply
+	default:
+		return errUnsupportedPatchType
+	}
+
+	// Use the readJSON() helper to read the patch, so that the same limits and checks
+	// apply as for any other request body.
+	var patch json.RawMessage
+
+	err := app.readJSON(w, r, &patch)
+	if err != nil {
+		return err
+	}
+
+	if mediaType != mergePatchMediaType && mediaType != jsonPatchMediaType && bytes.HasPrefix(bytes.TrimSpace(patch), []byte("[")) {
+		return errUnsupportedPatchType
+	}
+
+	original, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	patched, err := patchFunc(original, patch)
+	if err != nil {
+		return err
+	}
+
+	decodePatched(patched, doc, v)
+
+	return nil
+}
+
+// The decodePatched() function decodes a patched document into dst, which must be a
+// pointer to a struct. The patch may have added members which aren't fields of the
+// struct, or changed a field to a value of the wrong type, so instead of returning
+// these problems as errors they are recorded in v, in the same way as the validation
+// checks. The fields which the patch removed are left with their zero values.
+func decodePatched(patched []byte, dst interface{}, v *validator.Validator) {
+	// Reset the destination first, so that the fields which are missing from the
+	// patched document end up with their zero values.
+	value := reflect.ValueOf(dst).Elem()
+	value.Set(reflect.Zero(value.Type()))
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		return
+	}
+
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+		v.AddError(unmarshalTypeError.Field, "must be "+jsonTypeName(unmarshalTypeError.Type.Kind()))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		v.AddError(field, "is not a known field")
+	case errors.Is(err, data.ErrInvalidRuntimeFormat):
+		v.AddError("runtime", `must be in the format "<runtime> mins"`)
+	default:
+		v.AddError("body", "the patched document must be a JSON object")
+	}
+}
+
+// The jsonTypeName() function describes the JSON values which a Go value of the given
+// kind can be decoded from, for use in error messages.
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	default:
+		return "an integer"
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+	"github.com/mrojasb2000/greenlight/internal/jsonlog"
+)
+
+// The runPurgeCommand function handles the "purge" subcommand, which permanently
+// deletes the movies that were soft-deleted more than the retention period ago. It's
+// intended to be run periodically, for example from cron.
+func runPurgeCommand(models data.Models, logger *jsonlog.Logger, retention time.Duration) error {
+	if retention <= 0 {
+		return fmt.Errorf("the purge retention period must be positive, got %s", retention)
+	}
+
+	before := time.Now().Add(-retention)
+
+	purged, err := models.Movies.Purge(context.Background(), before)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("purged deleted movies", map[string]string{
+		"count":  strconv.FormatInt(purged, 10),
+		"before": before.UTC().Format(time.RFC3339),
+	})
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMovieRevisionHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writerUser, writer := newTestUser(t, app, "writer@example.com", true, "movies:read", "movies:write")
+	_, reader := newTestUser(t, app, "reader@example.com", true, "movies:read")
+	_, auditor := newTestUser(t, app, "auditor@example.com", true, "movies:read", "movies:audit")
+
+	// The read-only test cases share a single movie with three revisions.
+	id := newTestMovieWithHistory(t, ts, writer)
+
+	t.Run("List", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, withID("/v1/movies/{id}/revisions", id), "", auditor)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d (%s)", code, http.StatusOK, body)
+		}
+
+		var list struct {
+			Revisions []struct {
+				Version   int32  `json:"version"`
+				Operation string `json:"operation"`
+				UserID    int64  `json:"user_id"`
+				Client    string `json:"client"`
+				Movie     struct {
+					Title string `json:"title"`
+				} `json:"movie"`
+			} `json:"revisions"`
+		}
+		decode(t, body, &list)
+
+		if len(list.Revisions) != 3 {
+			t.Fatalf("got %d revisions; want 3", len(list.Revisions))
+		}
+
+		// The revisions are listed newest first, and record who made each change.
+		latest, first := list.Revisions[0], list.Revisions[2]
+		if latest.Version != 3 || latest.Operation != "update" || latest.Movie.Title != "Moana 3" {
+			t.Errorf("got latest revision %+v", latest)
+		}
+		if first.Version != 1 || first.Operation != "insert" || first.Movie.Title != "Moana" {
+			t.Errorf("got first revision %+v", first)
+		}
+		if first.UserID != writerUser.ID || first.Client != "127.0.0.1" {
+			t.Errorf("got user_id %d and client %q; want %d and %q", first.UserID, first.Client, writerUser.ID, "127.0.0.1")
+		}
+	})
+
+	// Users without the movies:audit permission don't see who made the changes.
+	t.Run("Audit fields hidden", func(t *testing.T) {
+		for _, path := range []string{"/v1/movies/{id}/revisions", "/v1/movies/{id}/revisions/1"} {
+			_, _, body := ts.do(t, http.MethodGet, withID(path, id), "", reader)
+			if strings.Contains(body, `"user_id"`) || strings.Contains(body, `"client"`) {
+				t.Errorf("%s: got audit fields without permission: %s", path, body)
+			}
+		}
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{"List oldest first", http.MethodGet, "/v1/movies/{id}/revisions?sort=version&page_size=1", reader, http.StatusOK, `"version":1`},
+		{"List page past the end", http.MethodGet, "/v1/movies/{id}/revisions?page=99", reader, http.StatusOK, `"revisions":[]`},
+		{"List invalid sort", http.MethodGet, "/v1/movies/{id}/revisions?sort=title", reader, http.StatusUnprocessableEntity, "invalid sort value"},
+		{"List missing movie", http.MethodGet, "/v1/movies/99/revisions", reader, http.StatusNotFound, "could not be found"},
+		{"Show", http.MethodGet, "/v1/movies/{id}/revisions/2", reader, http.StatusOK, `"title":"Moana 2"`},
+		{"Show missing", http.MethodGet, "/v1/movies/{id}/revisions/9", reader, http.StatusNotFound, "could not be found"},
+		{"Show invalid version", http.MethodGet, "/v1/movies/{id}/revisions/0", reader, http.StatusNotFound, "could not be found"},
+		{"Revert without permission", http.MethodPost, "/v1/movies/{id}/revisions/1/revert", reader, http.StatusForbidden, "necessary permissions"},
+		{"Revert missing revision", http.MethodPost, "/v1/movies/{id}/revisions/9/revert", writer, http.StatusNotFound, "could not be found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, tt.method, withID(tt.urlPath, id), "", tt.token)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// Reverting is tested as a sequence of requests, because what's being tested is that
+	// the revert changes the movie and is itself recorded as a revision.
+	t.Run("Revert", func(t *testing.T) {
+		id := newTestMovieWithHistory(t, ts, writer)
+
+		steps := []struct {
+			name     string
+			method   string
+			urlPath  string
+			token    string
+			wantCode int
+			wantBody string
+		}{
+			{"Revert", http.MethodPost, "/v1/movies/{id}/revisions/1/revert", writer, http.StatusOK, `"genres":["animation"],"version":4`},
+			{"Show reverted", http.MethodGet, "/v1/movies/{id}", reader, http.StatusOK, `"title":"Moana","year"`},
+			{"Show revert revision", http.MethodGet, "/v1/movies/{id}/revisions/4", reader, http.StatusOK, `"operation":"revert"`},
+		}
+
+		for _, step := range steps {
+			code, _, body := ts.do(t, step.method, withID(step.urlPath, id), "", step.token)
+
+			if code != step.wantCode {
+				t.Fatalf("%s: got status %d; want %d (%s)", step.name, code, step.wantCode, body)
+			}
+			if !strings.Contains(body, step.wantBody) {
+				t.Errorf("%s: got body %q; want it to contain %q", step.name, body, step.wantBody)
+			}
+		}
+	})
+
+	// Reverting is a change like any other, so it honours If-Match.
+	t.Run("Revert with stale If-Match", func(t *testing.T) {
+		id := newTestMovieWithHistory(t, ts, writer)
+
+		code, _, body := ts.doWithHeaders(t, http.MethodPost, withID("/v1/movies/{id}/revisions/2/revert", id), "", writer, headersWithID(http.Header{"If-Match": {`"{id}-2"`}}, id))
+		if code != http.StatusPreconditionFailed {
+			t.Errorf("got status %d; want %d (%s)", code, http.StatusPreconditionFailed, body)
+		}
+	})
+}
+
+// The newTestMovieWithHistory() helper creates a movie through the API and changes its
+// title twice, which gives it three revisions recorded against the given user. It
+// returns the ID of the movie.
+func newTestMovieWithHistory(t *testing.T, ts *testServer, token string) int64 {
+	t.Helper()
+
+	code, _, body := ts.do(t, http.MethodPost, "/v1/movies", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, token)
+	if code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d (%s)", code, http.StatusCreated, body)
+	}
+
+	var created struct {
+		Movie struct {
+			ID int64 `json:"id"`
+		} `json:"movie"`
+	}
+	decode(t, body, &created)
+
+	for _, title := range []string{"Moana 2", "Moana 3"} {
+		code, _, body := ts.do(t, http.MethodPatch, withID("/v1/movies/{id}", created.Movie.ID), `{"title": "`+title+`"}`, token)
+		if code != http.StatusOK {
+			t.Fatalf("update: got status %d; want %d (%s)", code, http.StatusOK, body)
+		}
+	}
+
+	return created.Movie.ID
+}
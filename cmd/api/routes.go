@@ -6,7 +6,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 	// Initialize a new httprouter instance.
 	router := httprouter.New()
 
@@ -26,13 +26,61 @@ func (app *application) routes() *httprouter.Router {
 	// respectively.
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	// Add the route for the PUT /v1/movies/:id endpoint
-	// Requeri a PATCH request, rather than PUT.
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
+
+	// Register the liveness and readiness probes.
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
+
+	// Use the requirePermission() middleware on each of the /v1/movies** endpoints,
+	// passing in the required permission code as the first parameter.
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	// Add the route for the PATCH /v1/movies/:id endpoint, which makes partial updates,
+	// and the PUT /v1/movies/:id endpoint, which replaces (or creates) a whole movie.
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission("movies:write", app.replaceMovieHandler))
 	// Add the route for the DELETE /v1/movies/:id endpoint
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	// Add the route for the POST /v1/movies/:id/restore endpoint, which undoes a delete.
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+
+	// Add the routes for the revision history of a movie, and for reverting a movie to
+	// one of its earlier revisions.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	// Add the routes for the /v1/books** endpoints, which use the "books:read" and
+	// "books:write" permissions in the same way.
+	router.HandlerFunc(http.MethodGet, "/v1/books", app.requirePermission("books:read", app.listBooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/books", app.requirePermission("books:write", app.createBookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id", app.requirePermission("books:read", app.showBookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", app.requirePermission("books:write", app.updateBookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", app.requirePermission("books:write", app.deleteBookHandler))
+
+	// Add the route for the POST /v1/users endpoint.
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+
+	// Add the route for the POST /v1/tokens/authentication endpoint.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	// Register the GET /debug/vars endpoint, which is only reachable by the clients
+	// allowed by the requireDebugAccess() middleware.
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requireDebugAccess(app.debugVarsHandler))
 
-	return router
+	// Wrap the router with the authenticate() middleware, so that the user for every
+	// request is available in the request context, and rateLimit() before it so that
+	// we don't hit the database for clients which are over their limit. Then wrap
+	// everything with the recoverPanic() middleware so that it also catches panics in
+	// other middleware. The requestID() middleware comes first of all, so that every
+	// error log entry (including those for panics) carries the request ID. The
+	// enableCORS() middleware runs before rateLimit(), so that even responses to
+	// rate-limited requests carry the CORS headers and are readable by the browser.
+	// Finally, recordMetrics() wraps the whole chain, so that every response is
+	// counted, including panics and rate-limited requests.
+	return app.recordMetrics(router, app.requestID(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))))
 }
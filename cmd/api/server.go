@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The serve() method starts the HTTP server and blocks until it has been shut down
+// gracefully after receiving a SIGINT or SIGTERM signal. It returns nil only when all
+// in-flight requests and background tasks have completed.
+func (app *application) serve() error {
+	// Close the done channel once the server has stopped, however that happens.
+	defer close(app.done)
+
+	// Create the base context for every request. It's cancelled if the grace period
+	// expires during a graceful shutdown, so that any handlers which are still running
+	// (and the database queries they are waiting on) are abandoned.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	// Use the httprouter instance returned by app.routes() as the server handler. We
+	// also create a new Go log.Logger instance with the log.New() function, passing in
+	// our custom Logger as the first parameter, so that any errors logged by the
+	// http.Server are written as JSON entries at the ERROR level.
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		ErrorLog:     log.New(app.logger, "", 0),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+	}
+
+	// If it's enabled, start the metrics server on its own port, so that the /metrics
+	// endpoint isn't exposed to the public along with the API. We call net.Listen()
+	// before starting the goroutine so that problems like the port already being in
+	// use are returned straight away.
+	var metricsSrv *http.Server
+	if app.config.metrics.port != 0 {
+		metricsSrv = &http.Server{
+			Addr:         fmt.Sprintf(":%d", app.config.metrics.port),
+			Handler:      app.metricsRoutes(),
+			ErrorLog:     log.New(app.logger, "", 0),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		ln, err := net.Listen("tcp", metricsSrv.Addr)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			app.logger.PrintInfo("starting metrics server", map[string]string{
+				"addr": metricsSrv.Addr,
+			})
+
+			err := metricsSrv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, nil)
+			}
+		}()
+	}
+
+	// Create a shutdownError channel. We will use this to receive any errors returned
+	// by the graceful Shutdown() function.
+	shutdownError := make(chan error)
+
+	// Start a background goroutine.
+	go func() {
+		// Create a quit channel which carries os.Signal values. The channel is
+		// buffered so that signal.Notify() never has to block when sending to it.
+		quit := make(chan os.Signal, 1)
+
+		// Use signal.Notify() to listen for incoming SIGINT and SIGTERM signals and
+		// relay them to the quit channel. Any other signals will not be caught by
+		// signal.Notify() and will retain their default behavior.
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		// Read the signal from the quit channel. This code will block until a signal
+		// is received.
+		s := <-quit
+
+		// Log a message to say that the signal has been caught. Notice that we also
+		// call the String() method on the signal to get the signal name.
+		app.logger.PrintInfo("shutting down server", map[string]string{
+			"signal": s.String(),
+		})
+
+		// Mark the application as shutting down, so that the readiness healthcheck
+		// reports it's unavailable for the remainder of the grace period.
+		atomic.StoreInt32(&app.shuttingDown, 1)
+
+		// Keep accepting requests for the drain delay before calling Shutdown(), which
+		// closes the listener straight away. Otherwise no load balancer would ever see
+		// the readiness healthcheck fail, and it would carry on sending requests until
+		// its connections were refused.
+		if app.config.shutdownDrain > 0 {
+			app.logger.PrintInfo("draining server", map[string]string{
+				"delay": app.config.shutdownDrain.String(),
+			})
+
+			time.Sleep(app.config.shutdownDrain)
+		}
+
+		// Create a context with the configured grace period as its timeout.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		// Call Shutdown() on the server, which stops accepting new connections and
+		// waits for in-flight requests to complete. If it returns an error (because
+		// the grace period expired first) we cancel the contexts of the requests which
+		// are still in flight, and relay the error to the shutdownError channel.
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			cancelBase()
+			shutdownError <- err
+			return
+		}
+
+		// Once the API server has stopped, we also stop the metrics server.
+		if metricsSrv != nil {
+			err = metricsSrv.Shutdown(ctx)
+			if err != nil {
+				shutdownError <- err
+				return
+			}
+		}
+
+		// Log a message to say that we're waiting for any background goroutines to
+		// complete their tasks.
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		// Call Wait() to block until our WaitGroup counter is zero --- essentially
+		// blocking until the background goroutines have finished. Then we return nil on
+		// the shutdownError channel, to indicate that the shutdown completed without
+		// any issues.
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
+	// Start the HTTP server
+	app.logger.PrintInfo("starting server", map[string]string{
+		"addr": srv.Addr,
+		"env":  app.config.env,
+	})
+
+	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
+	// return a http.ErrServerClosed error. So if we see this error, it is actually a
+	// good thing and an indication that the graceful shutdown has started. So we check
+	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	// Otherwise, we wait to receive the return value from Shutdown() on the
+	// shutdownError channel. If return value is an error, we know that there was a
+	// problem with the graceful shutdown and we return the error.
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	// At this point we know that the graceful shutdown completed successfully and we
+	// log a "stopped server" message.
+	app.logger.PrintInfo("stopped server", map[string]string{
+		"addr": srv.Addr,
+	})
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+	"github.com/mrojasb2000/greenlight/internal/jsonlog"
+	"github.com/mrojasb2000/greenlight/internal/mailer"
+	"github.com/mrojasb2000/greenlight/internal/metrics"
+)
+
+// Create a newTestApplication helper which returns an instance of our application
+// struct backed by the in-memory mock models and mailer, so that the handlers can be
+// exercised without a database or SMTP server. Log entries are discarded and rate
+// limiting is disabled. The app.done channel is closed when the test finishes, which
+// stops any background goroutines started by the middleware.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.healthcheck.timeout = time.Second
+	cfg.cors.trustedOrigins = []string{"https://www.example.com"}
+
+	app := &application{
+		config:  cfg,
+		logger:  jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models:  data.NewMockModels(),
+		mailer:  mailer.NewMemory(),
+		metrics: metrics.New(),
+		done:    make(chan struct{}),
+	}
+	t.Cleanup(func() { close(app.done) })
+
+	return app
+}
+
+// Define a custom testServer type which embeds a httptest.Server instance.
+type testServer struct {
+	*httptest.Server
+}
+
+// Create a newTestServer helper which initializes and returns a new instance of our
+// custom testServer type. The server is closed automatically when the test finishes.
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// The do() method makes a request to the test server, with an optional JSON body and
+// bearer token, and returns the response status code, headers and (compacted) body.
+func (ts *testServer) do(t *testing.T, method, urlPath, body, token string) (int, http.Header, string) {
+	t.Helper()
+
+	return ts.doWithHeaders(t, method, urlPath, body, token, nil)
+}
+
+// The doWithHeaders() method is like do(), but also sets the given request headers.
+func (ts *testServer) doWithHeaders(t *testing.T, method, urlPath, body, token string, headers http.Header) (int, http.Header, string) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Our JSON responses are indented, so compact them to make it easier to check for
+	// the expected content.
+	var compacted bytes.Buffer
+	if json.Compact(&compacted, b) == nil {
+		b = compacted.Bytes()
+	}
+
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(b))
+}
+
+// The decode() helper unmarshals a JSON response body into dst, failing the test if the
+// body isn't valid JSON.
+func decode(t *testing.T, body string, dst interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal([]byte(body), dst)
+	if err != nil {
+		t.Fatalf("invalid JSON response %q: %s", body, err)
+	}
+}
+
+// The newTestUser() helper inserts a user with the given activation status and
+// permissions into the mock models, and returns the user along with the plaintext of
+// a new authentication token for them.
+func newTestUser(t *testing.T, app *application, email string, activated bool, permissions ...string) (*data.User, string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user := &data.User{Name: "Alice Smith", Email: email, Activated: activated}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Permissions.AddForUser(ctx, user.ID, permissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+// The newTestMovie() helper inserts a movie into the mock models, for test cases which
+// need one to exist already. Each call creates a new movie, so that test cases which
+// change it don't affect each other.
+func newTestMovie(t *testing.T, app *application) *data.Movie {
+	t.Helper()
+
+	movie := &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+
+	err := app.models.Movies.Insert(context.Background(), movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
+
+// The newTestBook() helper inserts a book into the mock models, for test cases which
+// need one to exist already. Each book is given a new ISBN, because ISBNs must be
+// unique.
+func newTestBook(t *testing.T, app *application) *data.Book {
+	t.Helper()
+
+	for n := 1; ; n++ {
+		book := &data.Book{Title: "The Go Programming Language", Authors: []string{"Alan Donovan", "Brian Kernighan"}, ISBN: testISBN(n), PublishedYear: 2015, Pages: 380}
+
+		err := app.models.Books.Insert(context.Background(), book)
+		switch {
+		case errors.Is(err, data.ErrDuplicateISBN):
+			continue
+		case err != nil:
+			t.Fatal(err)
+		}
+
+		return book
+	}
+}
+
+// The testISBN() function returns the nth valid ISBN-13 starting with 978000.
+func testISBN(n int) string {
+	isbn := fmt.Sprintf("978%09d", n)
+
+	sum := 0
+	for i, c := range isbn {
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return isbn + strconv.Itoa((10-sum%10)%10)
+}
+
+// The withID() helper replaces every "{id}" in s with the given ID, so that test cases
+// can refer to the URL or ETag of a fixture which is only created when the case runs.
+func withID(s string, id int64) string {
+	return strings.ReplaceAll(s, "{id}", strconv.FormatInt(id, 10))
+}
+
+// The headersWithID() helper returns a copy of headers with withID() applied to every
+// value.
+func headersWithID(headers http.Header, id int64) http.Header {
+	h := make(http.Header, len(headers))
+	for key, values := range headers {
+		for _, value := range values {
+			h[key] = append(h[key], withID(value, id))
+		}
+	}
+
+	return h
+}
+
+// The lastMessage() helper waits for any background goroutines (which send the emails)
+// to complete, and then returns the most recent email sent by the application.
+func lastMessage(t *testing.T, app *application) mailer.Message {
+	t.Helper()
+
+	app.wg.Wait()
+
+	messages := app.mailer.(*mailer.MemoryMailer).Messages()
+	if len(messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+
+	return messages[len(messages)-1]
+}
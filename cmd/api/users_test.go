@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrojasb2000/greenlight/internal/data"
+)
+
+// The tokenRX regular expression extracts a token from the body of an email.
+var tokenRX = regexp.MustCompile(`"token": ?"([A-Z2-7]{26})"`)
+
+func TestRegisterUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "taken@example.com", true)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"Register", `{"name": "Alice Smith", "email": "alice@example.com", "password": "pa55word1234"}`, http.StatusAccepted, `"activated":false`},
+		{"Duplicate email", `{"name": "Alice Smith", "email": "taken@example.com", "password": "pa55word1234"}`, http.StatusUnprocessableEntity, "a user with this email address already exists"},
+		{"Invalid", `{"name": "", "email": "alice", "password": "short"}`, http.StatusUnprocessableEntity, "must be a valid email address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/v1/users", tt.body, "")
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// A new user is sent a welcome email containing an activation token.
+	t.Run("Welcome email", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodPost, "/v1/users", `{"name": "Bob Smith", "email": "bob@example.com", "password": "pa55word1234"}`, "")
+		if code != http.StatusAccepted {
+			t.Fatalf("got status %d; want %d (%s)", code, http.StatusAccepted, body)
+		}
+
+		msg := lastMessage(t, app)
+		if msg.Recipient != "bob@example.com" {
+			t.Errorf("got email for %q; want %q", msg.Recipient, "bob@example.com")
+		}
+		if tokenRX.FindStringSubmatch(msg.PlainBody) == nil {
+			t.Errorf("no activation token in email %q", msg.PlainBody)
+		}
+	})
+}
+
+func TestActivateUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	// Each test case gets its own inactive user and activation token. Any "{token}" in
+	// the body is replaced with the token.
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"Activate", `{"token": "{token}"}`, http.StatusOK, `"activated":true`},
+		{"Invalid token", `{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`, http.StatusUnprocessableEntity, "invalid or expired activation token"},
+		{"Malformed token", `{"token": "abc"}`, http.StatusUnprocessableEntity, "must be 26 bytes long"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := newActivationToken(t, app, fmt.Sprintf("user%d@example.com", i))
+
+			code, _, body := ts.do(t, http.MethodPut, "/v1/users/activated", strings.ReplaceAll(tt.body, "{token}", token), "")
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// An activation token can only be used once.
+	t.Run("Token reused", func(t *testing.T) {
+		token := newActivationToken(t, app, "reused@example.com")
+
+		code, _, body := ts.do(t, http.MethodPut, "/v1/users/activated", `{"token": "`+token+`"}`, "")
+		if code != http.StatusOK {
+			t.Fatalf("first use: got status %d; want %d (%s)", code, http.StatusOK, body)
+		}
+
+		code, _, body = ts.do(t, http.MethodPut, "/v1/users/activated", `{"token": "`+token+`"}`, "")
+		if code != http.StatusUnprocessableEntity || !strings.Contains(body, "invalid or expired activation token") {
+			t.Errorf("second use: got status %d; want %d (%s)", code, http.StatusUnprocessableEntity, body)
+		}
+	})
+}
+
+// The newActivationToken() helper creates an inactive user with the given email address,
+// and returns the plaintext of a new activation token for them.
+func newActivationToken(t *testing.T, app *application, email string) string {
+	t.Helper()
+
+	user, _ := newTestUser(t, app, email, false)
+
+	token, err := app.models.Tokens.New(context.Background(), user.ID, time.Hour, data.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
+
+func TestCreateAuthenticationToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "alice@example.com", true)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"Authenticate", `{"email": "alice@example.com", "password": "pa55word1234"}`, http.StatusCreated, `"authentication_token"`},
+		{"Wrong password", `{"email": "alice@example.com", "password": "wrongpa55word"}`, http.StatusUnauthorized, "invalid authentication credentials"},
+		{"Unknown email", `{"email": "bob@example.com", "password": "pa55word1234"}`, http.StatusUnauthorized, "invalid authentication credentials"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", tt.body, "")
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "alice@example.com", true)
+
+	t.Run("Unknown email", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", `{"email": "bob@example.com"}`, "")
+		if code != http.StatusAccepted || !strings.Contains(body, "you will receive an email") {
+			t.Errorf("got status %d; want %d (%s)", code, http.StatusAccepted, body)
+		}
+	})
+
+	// Resetting a password is tested as a sequence of requests, because what's being
+	// tested is the whole flow: requesting a token, using it, and not being able to use
+	// it again.
+	t.Run("Reset", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", `{"email": "alice@example.com"}`, "")
+		if code != http.StatusAccepted {
+			t.Fatalf("request token: got status %d; want %d (%s)", code, http.StatusAccepted, body)
+		}
+
+		msg := lastMessage(t, app)
+
+		match := tokenRX.FindStringSubmatch(msg.PlainBody)
+		if match == nil {
+			t.Fatalf("no token in email %q", msg.PlainBody)
+		}
+
+		code, _, body = ts.do(t, http.MethodPut, "/v1/users/password", `{"password": "newpa55word1234", "token": "`+match[1]+`"}`, "")
+		if code != http.StatusOK {
+			t.Fatalf("update password: got status %d; want %d (%s)", code, http.StatusOK, body)
+		}
+
+		code, _, body = ts.do(t, http.MethodPost, "/v1/tokens/authentication", `{"email": "alice@example.com", "password": "newpa55word1234"}`, "")
+		if code != http.StatusCreated {
+			t.Errorf("authenticate with new password: got status %d; want %d (%s)", code, http.StatusCreated, body)
+		}
+
+		code, _, body = ts.do(t, http.MethodPut, "/v1/users/password", `{"password": "otherpa55word1234", "token": "`+match[1]+`"}`, "")
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("token reused: got status %d; want %d (%s)", code, http.StatusUnprocessableEntity, body)
+		}
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, inactive := newTestUser(t, app, "inactive@example.com", false, "movies:read")
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{"Invalid token", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized, "invalid or missing authentication token"},
+		{"Inactive user", inactive, http.StatusForbidden, "must be activated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.do(t, http.MethodGet, "/v1/movies", "", tt.token)
+
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (%s)", code, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("got body %q; want it to contain %q", body, tt.wantBody)
+			}
+			if got := strings.Join(header.Values("Vary"), ", "); !strings.Contains(got, "Authorization") {
+				t.Errorf("got Vary header %q; want it to contain Authorization", got)
+			}
+		})
+	}
+}
@@ -1,27 +1,286 @@
 package data
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 
-// Define a BookModel struct type which wraps a sql.DB connection pool.
+	"github.com/lib/pq"
+	"github.com/mrojasb2000/greenlight/internal/validator"
+)
+
+// Define a custom ErrDuplicateISBN error, which is returned when a book with the same
+// ISBN is already in the database.
+var (
+	ErrDuplicateISBN = errors.New("duplicate isbn")
+)
+
+// Define a BookModel struct type which wraps a sql.DB connection pool, along with the
+// timeout which is applied to each query.
 type BookModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
-func (m BookModel) Insert(book *Book) error {
+// The Insert() method accepts a pointer to a book struct, which should contain the data
+// for the new record. The system-generated id, created_at and version fields are read
+// back into the struct using the RETURNING clause.
+func (m BookModel) Insert(ctx context.Context, book *Book) error {
+	query := `
+	INSERT INTO books (title, authors, isbn, published_year, pages)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, version`
+
+	args := []interface{}{book.Title, pq.Array(book.Authors), book.ISBN, book.PublishedYear, book.Pages}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	// If the table already contains a book with this ISBN, then the insert will violate
+	// the UNIQUE "books_isbn_key" constraint, in which case we return our custom
+	// ErrDuplicateISBN error instead.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "books_isbn_key"`:
+			return ErrDuplicateISBN
+		default:
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (m BookModel) Get(id int64) (*Book, error) {
-	return nil, nil
+// The Get() method fetches a specific record from the books table, returning an
+// ErrRecordNotFound error if there isn't a matching record.
+func (m BookModel) Get(ctx context.Context, id int64) (*Book, error) {
+	// As with movies, the bigserial IDs start at 1, so we don't need to query the
+	// database for smaller values.
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, title, authors, isbn, published_year, pages, version
+	FROM books
+	WHERE id = $1`
+
+	var book Book
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&book.ID,
+		&book.CreatedAt,
+		&book.Title,
+		pq.Array(&book.Authors),
+		&book.ISBN,
+		&book.PublishedYear,
+		&book.Pages,
+		&book.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &book, nil
+}
+
+// The GetAll() method returns a page of books, optionally filtered by title and author,
+// along with the pagination metadata. Both filters are case-insensitive, and the
+// author filter matches books which have the author as any one of their authors.
+func (m BookModel) GetAll(ctx context.Context, title string, author string, filters Filters) ([]*Book, Metadata, error) {
+	// As in MovieModel.GetAll(), the sort column and direction have been checked
+	// against the safelist, and we add a secondary sort on the book ID so that the
+	// ordering is consistent between pages.
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, authors, isbn, published_year, pages, version
+	FROM books
+	WHERE (LOWER(title) = LOWER($1) OR $1 = '')
+	AND ($2 = '' OR EXISTS (SELECT 1 FROM unnest(authors) AS a WHERE LOWER(a) = LOWER($2)))
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, author, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	books := []*Book{}
+
+	for rows.Next() {
+		var book Book
+
+		err := rows.Scan(
+			&totalRecords,
+			&book.ID,
+			&book.CreatedAt,
+			&book.Title,
+			pq.Array(&book.Authors),
+			&book.ISBN,
+			&book.PublishedYear,
+			&book.Pages,
+			&book.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		books = append(books, &book)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return books, metadata, nil
 }
 
-func (m BookModel) Update(book *Book) error {
+// The Update() method updates a specific record in the books table. Like
+// MovieModel.Update(), we use optimistic locking: the update only goes ahead if the
+// version in the database still matches the version of the book struct, and returns
+// an ErrEditConflict error otherwise.
+func (m BookModel) Update(ctx context.Context, book *Book) error {
+	query := `
+	UPDATE books
+	SET title = $1, authors = $2, isbn = $3, published_year = $4, pages = $5, version = version + 1
+	WHERE id = $6
+	AND version = $7
+	RETURNING version`
+
+	args := []interface{}{
+		book.Title,
+		pq.Array(book.Authors),
+		book.ISBN,
+		book.PublishedYear,
+		book.Pages,
+		book.ID,
+		book.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&book.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "books_isbn_key"`:
+			return ErrDuplicateISBN
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (m BookModel) Delete(id int64) error {
+// The Delete() method deletes a specific record from the books table, returning an
+// ErrRecordNotFound error if there isn't a matching record.
+func (m BookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM books
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
 	return nil
 }
 
+// Annotate the Book struct with struct tags to control how the keys appear in the
+// JSON-encoded output. The ISBN is stored as the 13 digits of an ISBN-13, without any
+// hyphens.
 type Book struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"-"`
+	Title         string    `json:"title"`
+	Authors       []string  `json:"authors"`
+	ISBN          string    `json:"isbn"`
+	PublishedYear int32     `json:"published_year"`
+	Pages         int32     `json:"pages"`
+	Version       int32     `json:"version"`
+}
+
+func ValidateBook(v *validator.Validator, book *Book) {
+	// Title
+	v.Check(book.Title != "", "title", "must be provided")
+	v.Check(len(book.Title) <= 500, "title", "must not be more than 500 bytes long")
+	// Authors
+	v.Check(book.Authors != nil, "authors", "must be provided")
+	v.Check(len(book.Authors) >= 1, "authors", "must contain at least 1 author")
+	v.Check(len(book.Authors) <= 10, "authors", "must not contain more than 10 authors")
+	v.Check(validator.Unique(book.Authors), "authors", "must not contain duplicate values")
+	for _, author := range book.Authors {
+		v.Check(author != "", "authors", "must not contain empty values")
+		v.Check(len(author) <= 500, "authors", "must not contain values more than 500 bytes long")
+	}
+	// ISBN
+	v.Check(book.ISBN != "", "isbn", "must be provided")
+	v.Check(ValidISBN13(book.ISBN), "isbn", "must be a valid ISBN-13 of 13 digits")
+	// Published year
+	v.Check(book.PublishedYear != 0, "published_year", "must be provided")
+	v.Check(book.PublishedYear > 0, "published_year", "must be a positive integer")
+	v.Check(book.PublishedYear <= int32(time.Now().Year()), "published_year", "must not be in the future")
+	// Pages
+	v.Check(book.Pages != 0, "pages", "must be provided")
+	v.Check(book.Pages > 0, "pages", "must be a positive integer")
+}
+
+// ValidISBN13 reports whether isbn is made up of exactly 13 digits with a valid check
+// digit. The check digit is calculated by weighting the first 12 digits alternately
+// by 1 and 3; the weighted sum of all 13 digits must then be a multiple of 10.
+func ValidISBN13(isbn string) bool {
+	if len(isbn) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i, c := range isbn {
+		if c < '0' || c > '9' {
+			return false
+		}
+
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
 }
@@ -0,0 +1,743 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Define a mockStore struct which holds the records for all of the mock models in
+// memory. The models share a single store (and a single mutex), so that, for example,
+// a token created with the mock TokenModel can be used to look up a user from the mock
+// UserModel, just as it would with the database.
+type mockStore struct {
+	mu sync.Mutex
+
+	movies      map[int64]*Movie
+	deleted     map[int64]time.Time
+	revisions   map[int64][]*MovieRevision
+	books       map[int64]*Book
+	users       map[int64]*User
+	tokens      []*Token
+	permissions map[int64]Permissions
+
+	lastMovieID int64
+	lastBookID  int64
+	lastUserID  int64
+}
+
+// Create a helper function which returns a Models instance containing the mock models
+// only. The mock models keep their records in memory and are safe for concurrent use,
+// so they can be used to run the handlers in tests without a database. They behave
+// like the real models: IDs, created_at and version fields are assigned on insert,
+// ErrRecordNotFound is returned for missing records and ErrEditConflict for updates
+// with a stale version.
+func NewMockModels() Models {
+	store := &mockStore{
+		movies:      make(map[int64]*Movie),
+		deleted:     make(map[int64]time.Time),
+		revisions:   make(map[int64][]*MovieRevision),
+		books:       make(map[int64]*Book),
+		users:       make(map[int64]*User),
+		permissions: make(map[int64]Permissions),
+	}
+
+	return Models{
+		Movies:         MockMovieModel{store: store},
+		MovieRevisions: MockMovieRevisionModel{store: store},
+		Books:          MockBookModel{store: store},
+		Permissions:    MockPermissionModel{store: store},
+		Tokens:         MockTokenModel{store: store},
+		Users:          MockUserModel{store: store},
+	}
+}
+
+// The copy helpers return a copy of a record, so that callers can never modify the
+// records in the store without going through the model methods.
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Genres = append([]string(nil), movie.Genres...)
+	return &c
+}
+
+func copyBook(book *Book) *Book {
+	c := *book
+	c.Authors = append([]string(nil), book.Authors...)
+	return &c
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	c.Password.hash = append([]byte(nil), user.Password.hash...)
+	return &c
+}
+
+type MockMovieModel struct {
+	store *mockStore
+}
+
+func (m MockMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastMovieID++
+	movie.ID = m.store.lastMovieID
+	movie.CreateAt = time.Now()
+	movie.Version = 1
+
+	m.store.movies[movie.ID] = copyMovie(movie)
+	m.store.addRevision(ctx, movie, OperationInsert)
+
+	return nil
+}
+
+func (m MockMovieModel) InsertWithID(ctx context.Context, movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if movie.ID < 1 || movie.ID > MaxClientMovieID {
+		return ErrInvalidID
+	}
+
+	if _, ok := m.store.movies[movie.ID]; ok {
+		return ErrDuplicateID
+	}
+
+	if movie.ID > m.store.lastMovieID {
+		m.store.lastMovieID = movie.ID
+	}
+	movie.CreateAt = time.Now()
+	movie.Version = 1
+
+	m.store.movies[movie.ID] = copyMovie(movie)
+	m.store.addRevision(ctx, movie, OperationInsert)
+
+	return nil
+}
+
+func (m MockMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok || m.isDeleted(id) {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+// GetAll() applies the same filters as MovieModel.GetAll(). The full-text search is
+// approximated by requiring every search word to be a prefix of one of the words in
+// the title, and as there's no ranking all movies have the same relevance.
+func (m MockMovieModel) GetAll(ctx context.Context, title string, genres []string, search string, filters Filters) ([]*Movie, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movies := []*Movie{}
+	for _, movie := range m.store.movies {
+		if m.isDeleted(movie.ID) {
+			continue
+		}
+		if title != "" && !strings.EqualFold(movie.Title, title) {
+			continue
+		}
+		if !containsAll(movie.Genres, genres) {
+			continue
+		}
+		if !matchesPrefixes(movie.Title, search) {
+			continue
+		}
+
+		movies = append(movies, copyMovie(movie))
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	sort.Slice(movies, func(i, j int) bool {
+		a, b := movies[i], movies[j]
+
+		var cmp int
+		switch column {
+		case "title":
+			cmp = strings.Compare(a.Title, b.Title)
+		case "year":
+			cmp = compareInt(int64(a.Year), int64(b.Year))
+		case "runtime":
+			cmp = compareInt(int64(a.Runtime), int64(b.Runtime))
+		case "id":
+			cmp = compareInt(a.ID, b.ID)
+		}
+
+		if desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+
+		return a.ID < b.ID
+	})
+
+	metadata := calculateMetadata(len(movies), filters.Page, filters.PageSize)
+	start, end := pageBounds(filters, len(movies))
+
+	return movies[start:end], metadata, nil
+}
+
+// The pageBounds() function returns the start and end indexes of the page described by
+// filters, in a sorted slice of n records.
+func pageBounds(filters Filters, n int) (int, int) {
+	start := filters.offset()
+	if start > n {
+		start = n
+	}
+	end := start + filters.limit()
+	if end > n {
+		end = n
+	}
+
+	return start, end
+}
+
+func (m MockMovieModel) Update(ctx context.Context, movie *Movie) error {
+	return m.update(ctx, movie, OperationUpdate)
+}
+
+func (m MockMovieModel) Revert(ctx context.Context, movie *Movie) error {
+	return m.update(ctx, movie, OperationRevert)
+}
+
+func (m MockMovieModel) update(ctx context.Context, movie *Movie, operation string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.movies[movie.ID]
+	if !ok || stored.Version != movie.Version || m.isDeleted(movie.ID) {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	m.store.movies[movie.ID] = copyMovie(movie)
+	m.store.addRevision(ctx, movie, operation)
+
+	return nil
+}
+
+func (m MockMovieModel) Delete(ctx context.Context, id int64, version int32) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.movies[id]
+	if !ok || stored.Version != version || m.isDeleted(id) {
+		return ErrEditConflict
+	}
+
+	stored.Version++
+	m.store.deleted[id] = time.Now()
+	m.store.addRevision(ctx, stored, OperationDelete)
+
+	return nil
+}
+
+func (m MockMovieModel) Restore(ctx context.Context, id int64) (*Movie, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.movies[id]
+	if !ok || !m.isDeleted(id) {
+		return nil, ErrRecordNotFound
+	}
+
+	stored.Version++
+	delete(m.store.deleted, id)
+	m.store.addRevision(ctx, stored, OperationRestore)
+
+	return copyMovie(stored), nil
+}
+
+func (m MockMovieModel) Purge(ctx context.Context, before time.Time) (int64, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var purged int64
+	for id, deletedAt := range m.store.deleted {
+		if deletedAt.Before(before) {
+			delete(m.store.movies, id)
+			delete(m.store.deleted, id)
+			delete(m.store.revisions, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// The isDeleted() method reports whether a movie has been soft-deleted. It must be
+// called with the store mutex held.
+func (m MockMovieModel) isDeleted(id int64) bool {
+	_, ok := m.store.deleted[id]
+	return ok
+}
+
+// The addRevision() method records the current state of a movie as a new revision,
+// like insertMovieRevision() does. It must be called with the store mutex held.
+func (s *mockStore) addRevision(ctx context.Context, movie *Movie, operation string) {
+	actor := actorFromContext(ctx)
+
+	s.revisions[movie.ID] = append(s.revisions[movie.ID], &MovieRevision{
+		MovieID:   movie.ID,
+		Version:   movie.Version,
+		Operation: operation,
+		UserID:    actor.UserID,
+		Client:    actor.Client,
+		CreatedAt: time.Now(),
+		Movie:     *copyMovie(movie),
+	})
+}
+
+func copyMovieRevision(revision *MovieRevision) *MovieRevision {
+	c := *revision
+	c.Movie = *copyMovie(&revision.Movie)
+	return &c
+}
+
+type MockMovieRevisionModel struct {
+	store *mockStore
+}
+
+func (m MockMovieRevisionModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if len(m.store.revisions[movieID]) == 0 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	revisions := []*MovieRevision{}
+	for _, revision := range m.store.revisions[movieID] {
+		revisions = append(revisions, copyMovieRevision(revision))
+	}
+
+	// The revisions can only be sorted by version, and they're stored in ascending
+	// version order.
+	if filters.sortColumn() == "version" && filters.sortDirection() == "DESC" {
+		for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+			revisions[i], revisions[j] = revisions[j], revisions[i]
+		}
+	}
+
+	metadata := calculateMetadata(len(revisions), filters.Page, filters.PageSize)
+
+	start := filters.offset()
+	if start > len(revisions) {
+		start = len(revisions)
+	}
+	end := start + filters.limit()
+	if end > len(revisions) {
+		end = len(revisions)
+	}
+
+	return revisions[start:end], metadata, nil
+}
+
+func (m MockMovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, revision := range m.store.revisions[movieID] {
+		if revision.Version == version {
+			return copyMovieRevision(revision), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+type MockBookModel struct {
+	store *mockStore
+}
+
+func (m MockBookModel) Insert(ctx context.Context, book *Book) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.isbnTaken(book.ISBN, 0) {
+		return ErrDuplicateISBN
+	}
+
+	m.store.lastBookID++
+	book.ID = m.store.lastBookID
+	book.CreatedAt = time.Now()
+	book.Version = 1
+
+	m.store.books[book.ID] = copyBook(book)
+
+	return nil
+}
+
+func (m MockBookModel) Get(ctx context.Context, id int64) (*Book, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	book, ok := m.store.books[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyBook(book), nil
+}
+
+func (m MockBookModel) GetAll(ctx context.Context, title string, author string, filters Filters) ([]*Book, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	books := []*Book{}
+	for _, book := range m.store.books {
+		if title != "" && !strings.EqualFold(book.Title, title) {
+			continue
+		}
+		if author != "" && !containsFold(book.Authors, author) {
+			continue
+		}
+
+		books = append(books, copyBook(book))
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	sort.Slice(books, func(i, j int) bool {
+		a, b := books[i], books[j]
+
+		var cmp int
+		switch column {
+		case "title":
+			cmp = strings.Compare(a.Title, b.Title)
+		case "published_year":
+			cmp = compareInt(int64(a.PublishedYear), int64(b.PublishedYear))
+		case "pages":
+			cmp = compareInt(int64(a.Pages), int64(b.Pages))
+		case "id":
+			cmp = compareInt(a.ID, b.ID)
+		}
+
+		if desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+
+		return a.ID < b.ID
+	})
+
+	metadata := calculateMetadata(len(books), filters.Page, filters.PageSize)
+	start, end := pageBounds(filters, len(books))
+
+	return books[start:end], metadata, nil
+}
+
+// The containsFold() function reports whether values contains s, ignoring case.
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m MockBookModel) Update(ctx context.Context, book *Book) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.books[book.ID]
+	if !ok || stored.Version != book.Version {
+		return ErrEditConflict
+	}
+
+	if m.isbnTaken(book.ISBN, book.ID) {
+		return ErrDuplicateISBN
+	}
+
+	book.Version++
+	m.store.books[book.ID] = copyBook(book)
+
+	return nil
+}
+
+func (m MockBookModel) Delete(ctx context.Context, id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.books[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.books, id)
+
+	return nil
+}
+
+// The isbnTaken() method mimics the UNIQUE constraint on the books.isbn column. It must
+// be called with the store mutex held.
+func (m MockBookModel) isbnTaken(isbn string, exceptID int64) bool {
+	for _, book := range m.store.books {
+		if book.ISBN == isbn && book.ID != exceptID {
+			return true
+		}
+	}
+
+	return false
+}
+
+type MockUserModel struct {
+	store *mockStore
+}
+
+func (m MockUserModel) Insert(ctx context.Context, user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	m.store.lastUserID++
+	user.ID = m.store.lastUserID
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	m.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (m MockUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// The email column has the case-insensitive citext type, so we use
+	// strings.EqualFold() to compare them here.
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m MockUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, token := range m.store.tokens {
+		if string(token.Hash) == string(tokenHash[:]) && token.Scope == tokenScope && token.Expiry.After(time.Now()) {
+			user, ok := m.store.users[token.UserID]
+			if !ok {
+				break
+			}
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m MockUserModel) Update(ctx context.Context, user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	stored, ok := m.store.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+	m.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+// The Activate() method marks the user as activated and deletes their activation tokens.
+func (m MockUserModel) Activate(ctx context.Context, user *User) error {
+	user.Activated = true
+
+	return m.updateAndDeleteTokens(user, ScopeActivation)
+}
+
+// The UpdatePassword() method saves the user and deletes their password reset tokens.
+func (m MockUserModel) UpdatePassword(ctx context.Context, user *User) error {
+	return m.updateAndDeleteTokens(user, ScopePasswordReset)
+}
+
+// The updateAndDeleteTokens() method mirrors the transaction in the UserModel, holding
+// the lock while it updates the user and deletes their tokens with the given scope.
+func (m MockUserModel) updateAndDeleteTokens(user *User, scope string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	stored, ok := m.store.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+	m.store.users[user.ID] = copyUser(user)
+	m.store.deleteTokens(scope, user.ID)
+
+	return nil
+}
+
+// The emailTaken() method mimics the UNIQUE constraint on the users.email column. It
+// must be called with the store mutex held.
+func (m MockUserModel) emailTaken(email string, exceptID int64) bool {
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) && user.ID != exceptID {
+			return true
+		}
+	}
+
+	return false
+}
+
+type MockTokenModel struct {
+	store *mockStore
+}
+
+func (m MockTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m MockTokenModel) Insert(ctx context.Context, token *Token) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	c := *token
+	m.store.tokens = append(m.store.tokens, &c)
+
+	return nil
+}
+
+func (m MockTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.deleteTokens(scope, userID)
+
+	return nil
+}
+
+// The deleteTokens() method removes the tokens with the given scope for a user. The
+// caller must hold the lock.
+func (s *mockStore) deleteTokens(scope string, userID int64) {
+	tokens := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.Scope != scope || token.UserID != userID {
+			tokens = append(tokens, token)
+		}
+	}
+	s.tokens = tokens
+}
+
+type MockPermissionModel struct {
+	store *mockStore
+}
+
+func (m MockPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return append(Permissions{}, m.store.permissions[userID]...), nil
+}
+
+func (m MockPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		if !m.store.permissions[userID].Include(code) {
+			m.store.permissions[userID] = append(m.store.permissions[userID], code)
+		}
+	}
+
+	return nil
+}
+
+// The containsAll() function reports whether values contains every one of the wanted
+// values, like the PostgreSQL @> array operator.
+func containsAll(values, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, v := range values {
+			if v == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The matchesPrefixes() function reports whether every word in the search text is a
+// prefix of one of the words in the title, ignoring case. This is the same as the
+// tsquery built by prefixTSQuery() matches with the 'simple' configuration.
+func matchesPrefixes(title, search string) bool {
+	isSeparator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	titleWords := strings.FieldsFunc(strings.ToLower(title), isSeparator)
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(search), isSeparator) {
+		found := false
+		for _, titleWord := range titleWords {
+			if strings.HasPrefix(titleWord, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMockMovieModel(t *testing.T) {
+	ctx := context.Background()
+	movies := NewMockModels().Movies
+
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+
+	err := movies.Insert(ctx, movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if movie.ID != 1 || movie.Version != 1 || movie.CreateAt.IsZero() {
+		t.Fatalf("got id %d, version %d and created_at %v after insert", movie.ID, movie.Version, movie.CreateAt)
+	}
+
+	_, err = movies.Get(ctx, 2)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for missing movie; want %v", err, ErrRecordNotFound)
+	}
+
+	// Read the movie twice, and update both copies. The second update has a stale
+	// version and should fail with an edit conflict, as it would with the database.
+	first, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.Title = "Moana 2"
+	err = movies.Update(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 {
+		t.Errorf("got version %d after update; want 2", first.Version)
+	}
+
+	err = movies.Update(ctx, second)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got error %v for stale update; want %v", err, ErrEditConflict)
+	}
+
+	// Changing the copy returned by Get() must not change the stored movie.
+	first.Genres[0] = "musical"
+
+	stored, err := movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Moana 2" || stored.Genres[0] != "animation" {
+		t.Errorf("got stored movie %+v", stored)
+	}
+
+	// Deleting with a stale version must fail, like updating does.
+	err = movies.Delete(ctx, movie.ID, 1)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got error %v for stale delete; want %v", err, ErrEditConflict)
+	}
+
+	err = movies.Delete(ctx, movie.ID, stored.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = movies.Delete(ctx, movie.ID, stored.Version+1)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got error %v for second delete; want %v", err, ErrEditConflict)
+	}
+
+	// Soft-deleted movies are hidden from Get() and GetAll() until they're restored.
+	_, err = movies.Get(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for deleted movie; want %v", err, ErrRecordNotFound)
+	}
+
+	all, _, err := movies.GetAll(ctx, "", nil, "", Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 0 {
+		t.Errorf("got %d movies from GetAll() after delete; want 0", len(all))
+	}
+
+	restored, err := movies.Restore(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 4 {
+		t.Errorf("got version %d after restore; want 4", restored.Version)
+	}
+
+	_, err = movies.Restore(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for restoring a movie which isn't deleted; want %v", err, ErrRecordNotFound)
+	}
+
+	// Only movies deleted before the cutoff time are purged.
+	err = movies.Delete(ctx, movie.ID, restored.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := movies.Purge(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("got %d, %v from Purge() with an old cutoff; want 0, nil", purged, err)
+	}
+
+	purged, err = movies.Purge(ctx, time.Now().Add(time.Second))
+	if err != nil || purged != 1 {
+		t.Errorf("got %d, %v from Purge(); want 1, nil", purged, err)
+	}
+
+	_, err = movies.Restore(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v for restoring a purged movie; want %v", err, ErrRecordNotFound)
+	}
+}
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 // Define a custom ErrRecordNotFound err. We'll return this from our Get() method when
@@ -12,40 +16,72 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// The IsQueryCanceled() function reports whether err is the PostgreSQL query_canceled
+// error (SQLSTATE 57014), which is returned when a query is cancelled part way through,
+// for example because its context was cancelled or the query timeout expired.
+func IsQueryCanceled(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
 // Create a Models struct which wraps the MovieModel We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
 	// Set the Movies field to be an interface containing the methods that both the
 	//'real' model and mock model need to support.
 	Movies interface {
-		Insert(movie *Movie) error
-		Get(id int64) (*Movie, error)
-		Update(movie *Movie) error
-		Delete(id int64) error
+		Insert(ctx context.Context, movie *Movie) error
+		InsertWithID(ctx context.Context, movie *Movie) error
+		Get(ctx context.Context, id int64) (*Movie, error)
+		GetAll(ctx context.Context, title string, genres []string, search string, filters Filters) ([]*Movie, Metadata, error)
+		Update(ctx context.Context, movie *Movie) error
+		Delete(ctx context.Context, id int64, version int32) error
+		Restore(ctx context.Context, id int64) (*Movie, error)
+		Revert(ctx context.Context, movie *Movie) error
+		Purge(ctx context.Context, before time.Time) (int64, error)
+	}
+	MovieRevisions interface {
+		GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error)
+		Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
 	}
 	//Movies MovieModel
 	Books interface {
-		Insert(book *Book) error
-		Get(id int64) (*Book, error)
-		Update(book *Book) error
-		Delete(id int64) error
+		Insert(ctx context.Context, book *Book) error
+		Get(ctx context.Context, id int64) (*Book, error)
+		GetAll(ctx context.Context, title string, author string, filters Filters) ([]*Book, Metadata, error)
+		Update(ctx context.Context, book *Book) error
+		Delete(ctx context.Context, id int64) error
 	}
-}
-
-// For ease of use, we also add a New() method which returns a Models struct containing
-// the initialized MovieModel.
-func NewModels(db *sql.DB) Models {
-	return Models{
-		Movies: MovieModel{DB: db},
-		Books:  BookModel{DB: db},
+	Users interface {
+		Insert(ctx context.Context, user *User) error
+		GetByEmail(ctx context.Context, email string) (*User, error)
+		GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+		Update(ctx context.Context, user *User) error
+		Activate(ctx context.Context, user *User) error
+		UpdatePassword(ctx context.Context, user *User) error
+	}
+	Permissions interface {
+		GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+		AddForUser(ctx context.Context, userID int64, codes ...string) error
+	}
+	Tokens interface {
+		New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+		Insert(ctx context.Context, token *Token) error
+		DeleteAllForUser(ctx context.Context, scope string, userID int64) error
 	}
 }
 
-// Create a helper function which returns a Models instance containing the mock models
-// only.
-func NewMockModels() Models {
+// For ease of use, we also add a New() method which returns a Models struct containing
+// the initialized MovieModel. Every method on the models accepts a context.Context,
+// which should be the context of the request being served, and each query is also
+// limited by the queryTimeout.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
 	return Models{
-		Movies: MovieModel{},
-		Books:  BookModel{},
+		Movies:         MovieModel{DB: db, QueryTimeout: queryTimeout},
+		MovieRevisions: MovieRevisionModel{DB: db, QueryTimeout: queryTimeout},
+		Books:          BookModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions:    PermissionModel{DB: db, QueryTimeout: queryTimeout},
+		Tokens:         TokenModel{DB: db, QueryTimeout: queryTimeout},
+		Users:          UserModel{DB: db, QueryTimeout: queryTimeout},
 	}
 }
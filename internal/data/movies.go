@@ -4,21 +4,40 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/mrojasb2000/greenlight/internal/validator"
 )
 
-// Define a MovieModel struct type which wraps a sql.DB connection pool.
+// Define a custom ErrDuplicateID error, which is returned by InsertWithID() when a
+// movie with the requested ID already exists (even if it has been soft-deleted).
+var ErrDuplicateID = errors.New("duplicate id")
+
+// MaxClientMovieID is the largest ID which clients can choose when they create a movie
+// with InsertWithID(). Inserting a movie with a client-chosen ID moves the sequence
+// which generates IDs past it, so without a limit a single request could use up all of
+// the IDs, and every later Insert() would fail.
+const MaxClientMovieID = 1<<31 - 1
+
+// Define a custom ErrInvalidID error, which is returned by InsertWithID() when the ID is
+// outside the range that clients can choose from.
+var ErrInvalidID = errors.New("invalid id")
+
+// Define a MovieModel struct type which wraps a sql.DB connection pool, along with the
+// timeout which is applied to each query.
 type MovieModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
 // Add a placeholder method for inserting a new record in the movies table.
 // The Insert() method accepts a pointer to a movie struct, which should contains the
 // data for the new record.
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	// Define the SQL query for inserting a new record in the movies table and returing
 	// the system-generated data.
 	query := `
@@ -31,16 +50,93 @@ func (m MovieModel) Insert(movie *Movie) error {
 	// make it nice and clear *what values are being used where* in the query
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the per-query timeout, derived from the caller's context.
+	// The timeout covers the whole transaction.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
+	// Begin a transaction, so that the movie and its first revision are inserted
+	// together. The deferred call to Rollback() is a no-op once the transaction has
+	// been committed.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Use QueryRowContext() and pass the context as the first argument.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreateAt, &movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreateAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, OperationInsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The InsertWithID() method inserts a new movie with the ID chosen by the client,
+// rather than one generated by the database. If a movie with that ID already exists,
+// an ErrDuplicateID error is returned.
+func (m MovieModel) InsertWithID(ctx context.Context, movie *Movie) error {
+	if movie.ID < 1 || movie.ID > MaxClientMovieID {
+		return ErrInvalidID
+	}
+
+	// Use ON CONFLICT DO NOTHING so that an existing ID results in no rows being
+	// returned, rather than a unique constraint error which would abort the
+	// transaction.
+	query := `
+	INSERT INTO movies (id, title, year, runtime, genres)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (id) DO NOTHING
+	RETURNING created_at, version`
+
+	args := []interface{}{movie.ID, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.CreateAt, &movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateID
+		default:
+			return err
+		}
+	}
+
+	// Inserting an explicit ID doesn't advance the sequence which generates the IDs for
+	// Insert(), so move it past the new ID. We never move it backwards, because
+	// another transaction may already have been given a higher ID.
+	query = `
+	SELECT setval('movies_id_seq', GREATEST($1, (SELECT last_value FROM movies_id_seq)))`
+
+	_, err = tx.ExecContext(ctx, query, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, OperationInsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	// The PosgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values
 	// less than that. To avoid making an unnecessary database call, we take a shortcut
@@ -48,17 +144,20 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	// Define the SQL query for retrieving the movie data.
+	// Define the SQL query for retrieving the movie data. Movies which have been
+	// soft-deleted are treated as if they don't exist.
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version FROM movies WHERE id = $1`
+	SELECT id, created_at, title, year, runtime, genres, version FROM movies
+	WHERE id = $1 AND deleted_at IS NULL`
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
 
-	// Use the context.WithTimeout() function to create a context.Context which carriers a
-	// 3-second timeout deadline. Note that we're using the empty context.Background()
-	// as the 'parent' context.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Use the context.WithTimeout() function to create a context.Context which carries
+	// the per-query timeout deadline. We use the context passed in by the caller as the
+	// 'parent' context, so the query is also cancelled if the client disconnects or the
+	// server shuts down.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 
 	// Importantly, use defer to make sure that we cancel the context before the Get()
 	// method returns
@@ -91,8 +190,126 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// Add a placeholder method for updating a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
+// Create a new GetAll() method which returns a slice of movies, along with the
+// pagination metadata. The title filter is a case-insensitive exact match, the genres
+// filter returns movies which contain *all* of the provided genres, and the search
+// parameter performs a ranked full-text search over the movie titles. Soft-deleted
+// movies are never included.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, search string, filters Filters) ([]*Movie, Metadata, error) {
+	// Use the count(*) OVER() window function to get the total number of filtered
+	// records alongside each row. The ORDER BY column and direction can't be passed as
+	// placeholder parameters, so we interpolate them with fmt.Sprintf() after they have
+	// been checked against the safelist. We also add a secondary sort on the movie ID
+	// so that the ordering is always consistent between pages.
+	//
+	// The to_tsvector('simple', title) expression must match the one used by the
+	// movies_title_idx GIN index exactly, otherwise PostgreSQL won't use the index.
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+		CASE WHEN $3 = '' THEN 0
+		ELSE ts_rank(to_tsvector('simple', title), to_tsquery('simple', $3)) END AS relevance
+	FROM movies
+	WHERE deleted_at IS NULL
+	AND (LOWER(title) = LOWER($1) OR $1 = '')
+	AND (genres @> $2 OR $2 = '{}')
+	AND ($3 = '' OR to_tsvector('simple', title) @@ to_tsquery('simple', $3))
+	ORDER BY %s %s, id ASC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	args := []interface{}{title, pq.Array(genres), prefixTSQuery(search), filters.limit(), filters.offset()}
+
+	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
+	// containing the result.
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Importantly, defer a call to rows.Close() to ensure that the resultset is closed
+	// before GetAll() returns.
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	// Use rows.Next to iterate through the rows in the resultset.
+	for rows.Next() {
+		var movie Movie
+		var relevance float64
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreateAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&relevance,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	// When the rows.Next() loop has finished, call rows.Err() to retrieve any error
+	// that was encountered during the iteration.
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// The SearchWords() function splits free text typed by the client into the words which
+// are used for searching. Anything other than letters and digits separates words and is
+// discarded, so a search made up only of punctuation has no words at all. Handlers
+// should use this to check the search text, and pass the words joined by spaces to
+// GetAll().
+func SearchWords(search string) []string {
+	return strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// The prefixTSQuery() helper converts free text typed by the client into a PostgreSQL
+// tsquery string where every word is treated as a prefix and all words must match,
+// so that "star wa" becomes "star:* & wa:*". Only the words returned by SearchWords()
+// are kept, which means the result is always safe to pass to to_tsquery(). An empty
+// string is returned if the input doesn't contain any searchable words.
+func prefixTSQuery(search string) string {
+	words := SearchWords(search)
+
+	for i, word := range words {
+		words[i] = strings.ToLower(word) + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// Add a placeholder method for updating a specific record in the movies table. The
+// new version of the movie is recorded in the movie_revisions table.
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+	return m.update(ctx, movie, OperationUpdate)
+}
+
+// The Revert() method saves a movie whose fields have been set from an earlier
+// revision. It works in exactly the same way as Update(), so reverting creates a new
+// version of the movie rather than rewriting its history, but the revision is recorded
+// with the "revert" operation.
+func (m MovieModel) Revert(ctx context.Context, movie *Movie) error {
+	return m.update(ctx, movie, OperationRevert)
+}
+
+func (m MovieModel) update(ctx context.Context, movie *Movie, operation string) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number
 	// Add the 'AND version = $6' clause to the SQL query.
@@ -101,18 +318,24 @@ func (m MovieModel) Update(movie *Movie) error {
 	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
 	WHERE id = $5
 	AND version = $6
+	AND deleted_at IS NULL
 	RETURNING version`
 
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version} // Add the expected movie version
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the per-query timeout, derived from the caller's context.
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	// Use QueryRowContext() and pass the context as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	// Use QueryRowContext() and pass the context as the first argument.
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -121,69 +344,149 @@ func (m MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
-	return nil
+
+	err = insertMovieRevision(ctx, tx, movie, operation)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// Add a placeholder method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
+// The Delete() method soft-deletes a movie, by setting its deleted_at timestamp rather
+// than removing the row, so that it can be restored later. Like Update(), it only
+// succeeds if the movie still has the expected version number, so a client can't
+// delete a movie which has been changed since they fetched it. If the movie has been
+// changed or deleted in the meantime, an ErrEditConflict error is returned.
+func (m MovieModel) Delete(ctx context.Context, id int64, version int32) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
+	// Construct the SQL query to soft-delete the record. We increment the version
+	// number too, so that any ETags for the movie are invalidated, and return the
+	// movie so that it can be recorded as a revision.
 	query := `
-	DELETE FROM movies
-	WHERE id = $1`
+	UPDATE movies
+	SET deleted_at = now(), version = version + 1
+	WHERE id = $1
+	AND version = $2
+	AND deleted_at IS NULL
+	RETURNING id, created_at, title, year, runtime, genres, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	// Execute the SQL query using the ExecContext() method, passing in the id variable as
-	// the value for the placeholder parameter. The Exec() method returns a sql.Result
-	// object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Call the RowsAffected() method on the sql.Result object to get number of rows
-	// affected by the query
-	rowsAffected, err := result.RowsAffected()
+	// If no rows are returned, then the movie has been updated or deleted since the
+	// caller fetched it (or it never existed), so we return an ErrEditConflict error.
+	movie, err := scanMovie(tx.QueryRowContext(ctx, query, id, version))
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = insertMovieRevision(ctx, tx, movie, OperationDelete)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-type MockMovieModel struct{}
+// The Restore() method undoes a soft delete, returning the restored movie. If there
+// isn't a soft-deleted movie with the given ID, an ErrRecordNotFound error is returned.
+func (m MovieModel) Restore(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
 
-func (m MockMovieModel) Insert(movie *Movie) error {
-	// Mock the action
-	return nil
-}
+	query := `
+	UPDATE movies
+	SET deleted_at = NULL, version = version + 1
+	WHERE id = $1
+	AND deleted_at IS NOT NULL
+	RETURNING id, created_at, title, year, runtime, genres, version`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	movie, err := scanMovie(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, OperationRestore)
+	if err != nil {
+		return nil, err
+	}
 
-func (m MockMovieModel) Get(id int64) (*Movie, error) {
-	// Mock the action
-	return nil, nil
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, nil
 }
 
-func (m MockMovieModel) Update(movie *Movie) error {
-	// Mock the action
-	return nil
+// The scanMovie() function scans a row containing the id, created_at, title, year,
+// runtime, genres and version columns, in that order, into a new Movie struct.
+func scanMovie(row scanner) (*Movie, error) {
+	var movie Movie
+
+	err := row.Scan(
+		&movie.ID,
+		&movie.CreateAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
 }
 
-func (m MockMovieModel) Delete(id int64) error {
-	// Mock the action
-	return nil
+// The Purge() method permanently deletes the movies which were soft-deleted before the
+// given time, and returns the number of movies deleted. Their revisions are deleted
+// along with them by the ON DELETE CASCADE foreign key. It's run from the command line
+// rather than for a request and may delete a lot of rows, so the per-query timeout
+// isn't applied; the caller's context controls how long it can run for.
+func (m MovieModel) Purge(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+	DELETE FROM movies
+	WHERE deleted_at < $1`
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
 
 // Annotate the Movie struct with struct tags to control how the keys appear in the
@@ -197,11 +500,16 @@ type Movie struct {
 	// still work on this: if the Runtime field has the underlying value 0, then it will
 	// be considered empty and omitted -- and the MarshalJSON() method we just made
 	// won.t be called at all.
-	Runtime Runtime  `json:"runtime, omitempty"`
+	Runtime Runtime  `json:"runtime,omitempty"`
 	Genres  []string `json:"genres,omitempty"` // Slice of geners for the movie (romace, comedy, etc.)
 	Version int32    `json:"version"`          // The version number start 1 and will be incremented each time the movie information is updated
 }
 
+// The ValidateClientMovieID() function checks an ID chosen by a client for a new movie.
+func ValidateClientMovieID(v *validator.Validator, id int64) {
+	v.Check(id <= MaxClientMovieID, "id", fmt.Sprintf("must not be greater than %d", MaxClientMovieID))
+}
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	// Title
 	v.Check(movie.Title != "", "title", "must be provided")
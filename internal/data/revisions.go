@@ -0,0 +1,222 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define constants for the operations which create a new version of a movie, and are
+// recorded in the movie_revisions table. OperationBaseline is only used by the
+// migration which created the table, for the versions of movies which already existed
+// at that point.
+const (
+	OperationBaseline = "baseline"
+	OperationInsert   = "insert"
+	OperationUpdate   = "update"
+	OperationDelete   = "delete"
+	OperationRestore  = "restore"
+	OperationRevert   = "revert"
+)
+
+// Define an Actor struct to hold the user and client (IP address) responsible for a
+// change to a movie, which is recorded with each revision.
+type Actor struct {
+	UserID int64
+	Client string
+}
+
+type contextKey string
+
+const actorContextKey = contextKey("actor")
+
+// The ContextWithActor() function returns a copy of ctx carrying the actor, which the
+// movie model methods record in the revisions they write. Passing the actor in the
+// context means the models don't need to know anything about HTTP requests.
+func ContextWithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// The actorFromContext() function returns the actor stored in ctx by
+// ContextWithActor(), or the zero Actor if there isn't one (for example, when a model
+// method is called from the command line).
+func actorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorContextKey).(Actor)
+	return actor
+}
+
+// Define a MovieRevision struct to hold a snapshot of a movie at a specific version,
+// along with the operation which created that version, and who made the change and
+// when. Every version of a movie gets a revision, so the state of the movie before any
+// change is the revision with the previous version number. The UserID and Client
+// fields are left out of the JSON when they are empty, which is how they are hidden
+// from users who aren't allowed to see them.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Operation string    `json:"operation"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Movie     Movie     `json:"movie"`
+}
+
+// Define a MovieRevisionModel struct type which wraps a sql.DB connection pool.
+type MovieRevisionModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// The GetAllForMovie() method returns the revisions of a movie, along with the
+// pagination metadata. The revisions of soft-deleted movies are included, so that it's
+// possible to see what a movie looked like before restoring it. If the movie doesn't
+// have any revisions at all (which means it doesn't exist, or has been purged) then
+// ErrRecordNotFound is returned.
+func (m MovieRevisionModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), movie_id, version, operation, title, year, runtime, genres,
+		user_id, client, created_at
+	FROM movie_revisions
+	WHERE movie_id = $1
+	ORDER BY %s %s
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := scanMovieRevision(rows, &revision, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// The count(*) OVER() column is only returned with the rows on the requested page,
+	// so an empty page doesn't tell us whether the movie exists. Check separately, so
+	// that asking for a page past the end returns an empty list rather than an error.
+	if len(revisions) == 0 {
+		var exists bool
+
+		err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_revisions WHERE movie_id = $1)`, movieID).Scan(&exists)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if !exists {
+			return nil, Metadata{}, ErrRecordNotFound
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// The Get() method returns a specific revision of a movie, or ErrRecordNotFound if it
+// doesn't exist.
+func (m MovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT movie_id, version, operation, title, year, runtime, genres, user_id, client, created_at
+	FROM movie_revisions
+	WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := scanMovieRevision(m.DB.QueryRowContext(ctx, query, movieID, version), &revision, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// The scanner interface is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// The scanMovieRevision() function scans a row from the movie_revisions table into
+// revision. If totalRecords isn't nil, the row is expected to start with the
+// count(*) OVER() column, which is scanned into it.
+func scanMovieRevision(row scanner, revision *MovieRevision, totalRecords *int) error {
+	var userID sql.NullInt64
+
+	dest := []interface{}{
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Operation,
+		&revision.Movie.Title,
+		&revision.Movie.Year,
+		&revision.Movie.Runtime,
+		pq.Array(&revision.Movie.Genres),
+		&userID,
+		&revision.Client,
+		&revision.CreatedAt,
+	}
+	if totalRecords != nil {
+		dest = append([]interface{}{totalRecords}, dest...)
+	}
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	revision.UserID = userID.Int64
+	revision.Movie.ID = revision.MovieID
+	revision.Movie.Version = revision.Version
+
+	return nil
+}
+
+// The insertMovieRevision() function records the current state of movie as a new
+// revision. It must be called in the same transaction as the change to the movie, so
+// that the movie and its history can never get out of step.
+func insertMovieRevision(ctx context.Context, tx *sql.Tx, movie *Movie, operation string) error {
+	query := `
+	INSERT INTO movie_revisions (movie_id, version, operation, title, year, runtime, genres, user_id, client)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	actor := actorFromContext(ctx)
+
+	// Store a NULL user_id if there isn't a user, rather than 0, which would violate
+	// the foreign key constraint.
+	userID := sql.NullInt64{Int64: actor.UserID, Valid: actor.UserID != 0}
+
+	args := []interface{}{movie.ID, movie.Version, operation, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), userID, actor.Client}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
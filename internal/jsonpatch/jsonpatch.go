@@ -0,0 +1,460 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON documents.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Define custom errors for the two ways that applying a patch can fail. ErrInvalidPatch
+// means that the patch itself is malformed, and ErrConflict means that the patch is
+// well-formed but can't be applied to the document, for example because it refers to a
+// location which doesn't exist or a test operation failed. The errors returned by the
+// functions in this package wrap one of these, and describe the problem in more detail.
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrConflict     = errors.New("patch cannot be applied")
+)
+
+// MergePatch applies a JSON Merge Patch to the JSON document doc, and returns the
+// patched document. Members of the patch which are objects are merged into the
+// document recursively, members which are null are removed from the document, and any
+// other value replaces the existing one. A patch which isn't an object replaces the
+// whole document.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+// The mergePatch() function implements the MergePatch algorithm from section 2 of RFC
+// 7396 on decoded JSON values.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergePatch(t[key], value)
+	}
+
+	return t
+}
+
+// Define an operation struct to hold a single operation from a JSON Patch document.
+type operation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// Apply applies a JSON Patch, which is an array of operations, to the JSON document
+// doc and returns the patched document. The operations are applied in order, and if
+// any of them fails the whole patch fails and the document is left unchanged.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	operations, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range operations {
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// The parsePatch() function decodes and checks a JSON Patch document. Every operation
+// must have an op and a path member, and the from and value members which its op
+// requires.
+func parsePatch(patch []byte) ([]operation, error) {
+	var raw []map[string]json.RawMessage
+
+	err := json.Unmarshal(patch, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: a JSON Patch must be an array of operation objects", ErrInvalidPatch)
+	}
+
+	operations := make([]operation, len(raw))
+
+	for i, members := range raw {
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%w: operation %d: %s", ErrInvalidPatch, i, fmt.Sprintf(format, args...))
+		}
+
+		op := &operations[i]
+
+		for _, member := range []struct {
+			name string
+			dst  *string
+		}{{"op", &op.Op}, {"path", &op.Path}, {"from", &op.From}} {
+			value, ok := members[member.name]
+			if !ok {
+				continue
+			}
+			if json.Unmarshal(value, member.dst) != nil {
+				return nil, invalid("%q must be a string", member.name)
+			}
+		}
+
+		if _, ok := members["op"]; !ok {
+			return nil, invalid(`missing "op" member`)
+		}
+		if _, ok := members["path"]; !ok {
+			return nil, invalid(`missing "path" member`)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			value, ok := members["value"]
+			if !ok {
+				return nil, invalid(`missing "value" member`)
+			}
+			if err := json.Unmarshal(value, &op.Value); err != nil {
+				return nil, invalid(`invalid "value" member`)
+			}
+		case "move", "copy":
+			if _, ok := members["from"]; !ok {
+				return nil, invalid(`missing "from" member`)
+			}
+		case "remove":
+		default:
+			return nil, invalid("unknown op %q", op.Op)
+		}
+
+		_, err := parsePointer(op.Path)
+		if err == nil && (op.Op == "move" || op.Op == "copy") {
+			_, err = parsePointer(op.From)
+		}
+		if err != nil {
+			return nil, invalid("%s", err)
+		}
+	}
+
+	return operations, nil
+}
+
+// The apply() method applies a single operation to the document, and returns the new
+// document. The document may be modified in place.
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(op.Path)
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, op.Value)
+
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+
+	case "replace":
+		doc, _, err := remove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, op.Value)
+
+	case "move":
+		from, _ := parsePointer(op.From)
+
+		// A value can't be moved into one of its own children.
+		if isProperPrefix(from, path) {
+			return nil, fmt.Errorf("%w: %q is a child of %q", ErrConflict, op.Path, op.From)
+		}
+
+		doc, value, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "copy":
+		from, _ := parsePointer(op.From)
+
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, deepCopy(value))
+
+	case "test":
+		value, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		// The values decoded by encoding/json are maps, slices, strings, float64s,
+		// bools and nil, so reflect.DeepEqual() compares them in the way required by
+		// section 4.6 of RFC 6902.
+		if !reflect.DeepEqual(value, op.Value) {
+			return nil, fmt.Errorf("%w: test failed for %q", ErrConflict, op.Path)
+		}
+		return doc, nil
+	}
+
+	panic("unknown op " + op.Op)
+}
+
+// The parsePointer() function splits a JSON Pointer (RFC 6901) into its reference
+// tokens, unescaping "~1" to "/" and "~0" to "~". The empty pointer refers to the whole
+// document and has no tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// The isProperPrefix() function reports whether the pointer with the tokens prefix
+// refers to an ancestor of the location referred to by path.
+func isProperPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The arrayIndex() function converts a reference token to an index into an array of
+// length n. The index must be a number without leading zeros, and less than n, or less
+// than or equal to n when adding. When adding, "-" refers to the end of the array.
+func arrayIndex(token string, n int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return n, nil
+	}
+
+	max := n - 1
+	if adding {
+		max = n
+	}
+
+	// RFC 6901 only allows "0" or digits without a leading zero, so check that before
+	// calling strconv.Atoi(), which also accepts a sign.
+	if !isArrayIndex(token) {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrConflict, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i > max {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrConflict, token)
+	}
+
+	return i, nil
+}
+
+// The isArrayIndex() function reports whether token is an array index as defined by
+// RFC 6901: either "0", or ASCII digits which don't start with a zero.
+func isArrayIndex(token string) bool {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return false
+	}
+
+	for i := 0; i < len(token); i++ {
+		if token[i] < '0' || token[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The get() function returns the value at the location in the document referred to by
+// path.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q does not exist", ErrConflict, token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: cannot refer to %q inside a value which is not an object or array", ErrConflict, token)
+		}
+	}
+
+	return doc, nil
+}
+
+// The add() function adds value to the document at the location referred to by path,
+// and returns the new document. The parent of the location must already exist. If it's
+// an object, the member is added or replaced, and if it's an array the value is
+// inserted at the index, shifting any later elements up.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+
+		// Appending may have reallocated the array, so store it back in its parent.
+		return replaceAt(doc, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("%w: cannot add %q to a value which is not an object or array", ErrConflict, last)
+	}
+}
+
+// The remove() function removes the value at the location referred to by path from the
+// document, and returns the new document along with the value which was removed.
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: member %q does not exist", ErrConflict, last)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value := node[i]
+		node = append(node[:i], node[i+1:]...)
+
+		doc, err = replaceAt(doc, path[:len(path)-1], node)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("%w: cannot remove %q from a value which is not an object or array", ErrConflict, last)
+	}
+}
+
+// The replaceAt() function replaces the existing value at the location referred to by
+// path with value, and returns the new document.
+func replaceAt(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+
+	return doc, nil
+}
+
+// The deepCopy() function returns a copy of a decoded JSON value which doesn't share
+// any maps or slices with the original.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			c[key] = deepCopy(child)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, child := range v {
+			c[i] = deepCopy(child)
+		}
+		return c
+	default:
+		return v
+	}
+}
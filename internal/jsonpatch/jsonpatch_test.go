@@ -0,0 +1,123 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// The assertJSON() helper checks that two JSON documents are equal, ignoring the order
+// of object members and whitespace.
+func assertJSON(t *testing.T, name string, got []byte, want string) {
+	t.Helper()
+
+	var g, w interface{}
+
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("%s: invalid JSON %q: %s", name, got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("%s: invalid JSON %q in test case: %s", name, want, err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("%s: got %s; want %s", name, got, want)
+	}
+}
+
+// The test cases are taken from the examples in appendix A of RFC 7396.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: unexpected error: %s", tt.doc, tt.patch, err)
+			continue
+		}
+		assertJSON(t, tt.doc+" + "+tt.patch, got, tt.want)
+	}
+
+	_, err := MergePatch([]byte(`{}`), []byte(`{"a":`))
+	if !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("got error %v for malformed patch; want %v", err, ErrInvalidPatch)
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := `{"title":"Moana","genres":["animation","adventure"],"a/b":{"m~n":1}}`
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{"Add member", `[{"op":"add","path":"/year","value":2016}]`, `{"title":"Moana","genres":["animation","adventure"],"a/b":{"m~n":1},"year":2016}`, nil},
+		{"Add array element", `[{"op":"add","path":"/genres/1","value":"musical"}]`, `{"title":"Moana","genres":["animation","musical","adventure"],"a/b":{"m~n":1}}`, nil},
+		{"Append array element", `[{"op":"add","path":"/genres/-","value":"musical"}]`, `{"title":"Moana","genres":["animation","adventure","musical"],"a/b":{"m~n":1}}`, nil},
+		{"Remove array element", `[{"op":"remove","path":"/genres/0"}]`, `{"title":"Moana","genres":["adventure"],"a/b":{"m~n":1}}`, nil},
+		{"Remove escaped member", `[{"op":"remove","path":"/a~1b/m~0n"}]`, `{"title":"Moana","genres":["animation","adventure"],"a/b":{}}`, nil},
+		{"Replace", `[{"op":"replace","path":"/title","value":"Moana 2"}]`, `{"title":"Moana 2","genres":["animation","adventure"],"a/b":{"m~n":1}}`, nil},
+		{"Replace null", `[{"op":"replace","path":"/title","value":null}]`, `{"title":null,"genres":["animation","adventure"],"a/b":{"m~n":1}}`, nil},
+		{"Move", `[{"op":"move","from":"/genres/0","path":"/genres/1"}]`, `{"title":"Moana","genres":["adventure","animation"],"a/b":{"m~n":1}}`, nil},
+		{"Copy", `[{"op":"copy","from":"/genres","path":"/tags"},{"op":"add","path":"/tags/-","value":"x"}]`, `{"title":"Moana","genres":["animation","adventure"],"tags":["animation","adventure","x"],"a/b":{"m~n":1}}`, nil},
+		{"Test and replace", `[{"op":"test","path":"/genres/0","value":"animation"},{"op":"replace","path":"/genres/0","value":"musical"}]`, `{"title":"Moana","genres":["musical","adventure"],"a/b":{"m~n":1}}`, nil},
+		{"Test number", `[{"op":"test","path":"/a~1b/m~0n","value":1.0}]`, doc, nil},
+		{"Replace document", `[{"op":"replace","path":"","value":{"x":1}}]`, `{"x":1}`, nil},
+		{"Test failed", `[{"op":"test","path":"/title","value":"Frozen"}]`, "", ErrConflict},
+		{"Remove missing member", `[{"op":"remove","path":"/year"}]`, "", ErrConflict},
+		{"Replace missing member", `[{"op":"replace","path":"/year","value":2016}]`, "", ErrConflict},
+		{"Add to missing parent", `[{"op":"add","path":"/x/y","value":1}]`, "", ErrConflict},
+		{"Index out of range", `[{"op":"add","path":"/genres/3","value":"x"}]`, "", ErrConflict},
+		{"Index with leading zero", `[{"op":"remove","path":"/genres/01"}]`, "", ErrConflict},
+		{"Index with plus sign", `[{"op":"remove","path":"/genres/+1"}]`, "", ErrConflict},
+		{"Negative zero index", `[{"op":"remove","path":"/genres/-0"}]`, "", ErrConflict},
+		{"Add at index with plus sign", `[{"op":"add","path":"/genres/+1","value":"musical"}]`, "", ErrConflict},
+		{"Move into child", `[{"op":"move","from":"/a~1b","path":"/a~1b/c"}]`, "", ErrConflict},
+		{"Not an array", `{"op":"remove","path":"/title"}`, "", ErrInvalidPatch},
+		{"Unknown op", `[{"op":"delete","path":"/title"}]`, "", ErrInvalidPatch},
+		{"Missing path", `[{"op":"remove"}]`, "", ErrInvalidPatch},
+		{"Missing value", `[{"op":"add","path":"/year"}]`, "", ErrInvalidPatch},
+		{"Missing from", `[{"op":"copy","path":"/year"}]`, "", ErrInvalidPatch},
+		{"Invalid pointer", `[{"op":"remove","path":"title"}]`, "", ErrInvalidPatch},
+	}
+
+	for _, tt := range tests {
+		got, err := Apply([]byte(doc), []byte(tt.patch))
+
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got error %v; want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		assertJSON(t, tt.name, got, tt.want)
+	}
+}
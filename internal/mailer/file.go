@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Define a FileMailer struct which writes every email to a .eml file in a directory
+// instead of sending it, so that we can run the application during development without
+// an SMTP server. The files can be opened with any email client.
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+// NewFile returns a FileMailer which writes emails to the given directory, creating it
+// if it doesn't already exist.
+func NewFile(dir, sender string) (FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return FileMailer{}, err
+	}
+
+	return FileMailer{dir: dir, sender: sender}, nil
+}
+
+// Send() renders the email from the template file and writes it to a new file named
+// after the current time, the template and the recipient.
+func (m FileMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.bytes(m.sender)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s_%s_%s.eml", time.Now().Format("20060102T150405.000000000"), templateFile, safeFileName(recipient))
+
+	// As a last line of defence, check that the file is still inside the directory.
+	path := filepath.Join(m.dir, name)
+	if filepath.Dir(path) != filepath.Clean(m.dir) {
+		return fmt.Errorf("invalid email file name %q", name)
+	}
+
+	return os.WriteFile(path, body, 0o644)
+}
+
+// The safeFileName() function makes an email address safe to use in a file name. Email
+// addresses can contain path separators and "..", which could otherwise be used to
+// write the file outside the mailer directory, so every character other than letters,
+// digits and "@._-" is replaced with an underscore, as is every "..".
+func safeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '@', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+
+	for strings.Contains(s, "..") {
+		s = strings.ReplaceAll(s, "..", "_")
+	}
+
+	return s
+}
+
+// Ping() checks that the directory which the emails are written to still exists.
+func (m FileMailer) Ping(ctx context.Context) error {
+	info, err := os.Stat(m.dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", m.dir)
+	}
+
+	return nil
+}
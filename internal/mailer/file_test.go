@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerSend(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "mail")
+
+	m, err := NewFile(dir, "Greenlight <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The validator accepts this address, because "/" and "." are allowed in the local
+	// part of an email address.
+	err = m.Send("a/../../x@example.com", "token_activation.tmpl", map[string]interface{}{"activationToken": "ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "mail" {
+		t.Errorf("got files %v outside the mailer directory", entries)
+	}
+
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d files in the mailer directory; want 1", len(entries))
+	}
+	if name := entries[0].Name(); !strings.HasSuffix(name, "_token_activation.tmpl_a_____x@example.com.eml") {
+		t.Errorf("got file name %q", name)
+	}
+}
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice@example.com", "alice@example.com"},
+		{"a/../../../../tmp/x@example.com", "a_________tmp_x@example.com"},
+		{"a...b@example.com", "a_.b@example.com"},
+		{`a\b@example.com`, "a_b@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := safeFileName(tt.in)
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+			if strings.ContainsAny(got, `/\`) || strings.Contains(got, "..") {
+				t.Errorf("got unsafe name %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer is the interface which the application uses to deliver emails. Each
+// implementation renders the named template from the embedded file system with the
+// dynamic data, and then delivers the resulting message in its own way. Keeping it this
+// small means we can swap SMTP for the file or in-memory implementations during
+// development and testing without touching any of our handlers.
+type Mailer interface {
+	Send(recipient, templateFile string, data interface{}) error
+}
+
+// Pinger is implemented by the mailers which can check that they are able to deliver
+// emails, without actually sending one. It's used by the readiness healthcheck.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Define a Message struct to hold a rendered email, ready to be delivered.
+type Message struct {
+	Recipient string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// The render() function executes the "subject", "plainBody" and "htmlBody" named
+// templates from the given template file, passing in the dynamic data, and returns
+// the result as a Message.
+func render(recipient, templateFile string, data interface{}) (*Message, error) {
+	// Use the ParseFS() method to parse the required template file from the embedded
+	// file system. The subject and plain-text body are parsed with text/template,
+	// because we don't want any HTML escaping applied to them.
+	tmpl, err := texttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the named template "subject", passing in the dynamic data and storing the
+	// result in a bytes.Buffer variable.
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Follow the same pattern to execute the "plainBody" template and store the result
+	// in the plainBody variable.
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTML body is parsed again with html/template so that the dynamic data is
+	// escaped correctly.
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Recipient: recipient,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: strings.TrimSpace(plainBody.String()),
+		HTMLBody:  strings.TrimSpace(htmlBody.String()),
+	}
+
+	return msg, nil
+}
+
+// The bytes() method encodes the message as a MIME multipart/alternative email from the
+// given sender, with the plain-text body first and the HTML body second so that email
+// clients prefer the HTML version when they are able to display it. Note that the SMTP
+// protocol requires CRLF line endings.
+func (msg *Message) bytes(sender string) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.PlainBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "8bit")
+
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = pw.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n") + "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.Recipient)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n", mw.Boundary())
+	fmt.Fprintf(&buf, "\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
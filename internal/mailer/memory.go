@@ -0,0 +1,43 @@
+package mailer
+
+import "sync"
+
+// Define a MemoryMailer struct which keeps every email it is asked to send in memory.
+// It is safe for concurrent use, and is intended for tests which need to check the
+// emails sent by the application (for example, to read an activation token).
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemory returns an empty MemoryMailer.
+func NewMemory() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send() renders the email from the template file and stores the result.
+func (m *MemoryMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *msg)
+
+	return nil
+}
+
+// Messages() returns a copy of all the emails which have been sent so far, in the
+// order that they were sent.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+
+	return messages
+}
@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// Define a SMTPMailer struct which contains the address of the SMTP server, the
+// credentials to authenticate with, the sender information that you want the email to
+// be from (like "Alice Smith <alice@example.com>"), and how many times a transient
+// failure should be retried.
+type SMTPMailer struct {
+	addr    string
+	auth    smtp.Auth
+	sender  string
+	retries int
+}
+
+// NewSMTP returns a SMTPMailer for the given SMTP server settings. If no username is
+// provided we don't attempt to authenticate, which is what local stand-ins like
+// MailHog expect.
+func NewSMTP(host string, port int, username, password, sender string, retries int) SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return SMTPMailer{
+		addr:    fmt.Sprintf("%s:%d", host, port),
+		auth:    auth,
+		sender:  sender,
+		retries: retries,
+	}
+}
+
+// Send() renders the email from the template file and delivers it to the recipient
+// using the SMTP server. Transient failures are retried up to m.retries times, sleeping
+// for 500 milliseconds between each attempt.
+func (m SMTPMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.bytes(m.sender)
+	if err != nil {
+		return err
+	}
+
+	// The SMTP envelope needs the bare email address of the sender, without any display
+	// name, so we parse it out of the sender string.
+	from, err := mail.ParseAddress(m.sender)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err = smtp.SendMail(m.addr, m.auth, from.Address, []string{recipient}, body)
+		if err == nil || i >= m.retries || !isTransient(err) {
+			return err
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Ping() checks that the SMTP server is reachable and responding, by connecting to it
+// and exchanging greetings before quitting. No email is sent.
+func (m SMTPMailer) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Make sure that a slow SMTP server can't block us for longer than the context
+	// allows.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, err := net.SplitHostPort(m.addr)
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+
+	err = c.Hello("localhost")
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// The isTransient() function reports whether an error from the SMTP server is worth
+// retrying. Network errors (like a refused connection or a timeout) and SMTP 4xx
+// replies are temporary by definition, whereas 5xx replies are permanent failures.
+func isTransient(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
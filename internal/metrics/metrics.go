@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the value of the Content-Type header for responses in the Prometheus
+// text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The upper bounds (in seconds) of the request latency histogram buckets. These are
+// the same default buckets which the official Prometheus client libraries use, and
+// cover everything from a 5ms response to a 10s one.
+var buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Define a routeKey struct to identify a route by its pattern (like "/v1/movies/:id",
+// rather than the raw URL, so that the number of series stays bounded) and HTTP method.
+type routeKey struct {
+	route  string
+	method string
+}
+
+// Define a responseKey struct, which adds the status code to a routeKey.
+type responseKey struct {
+	routeKey
+	status int
+}
+
+// Define a histogram struct to hold the number of observations in each bucket, along
+// with the sum and count of all observations. Note that the bucket counts are *not*
+// cumulative here; they are accumulated when the metrics are written.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Metrics holds the HTTP request metrics for the application. It is safe for
+// concurrent use by multiple goroutines.
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[routeKey]uint64
+	responses map[responseKey]uint64
+	inFlight  map[routeKey]int64
+	durations map[routeKey]*histogram
+}
+
+// New returns a new, empty, Metrics instance.
+func New() *Metrics {
+	return &Metrics{
+		requests:  make(map[routeKey]uint64),
+		responses: make(map[responseKey]uint64),
+		inFlight:  make(map[routeKey]int64),
+		durations: make(map[routeKey]*histogram),
+	}
+}
+
+// RequestStarted records that a request for the given route and method has been
+// received and is now in flight.
+func (m *Metrics) RequestStarted(route, method string) {
+	key := routeKey{route, method}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[key]++
+	m.inFlight[key]++
+}
+
+// RequestFinished records that the response for a request has been sent, with the
+// given status code, after the given duration.
+func (m *Metrics) RequestFinished(route, method string, status int, duration time.Duration) {
+	key := routeKey{route, method}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[key]--
+	m.responses[responseKey{key, status}]++
+
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(buckets))}
+		m.durations[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// Write writes the HTTP request metrics to w in the Prometheus text exposition
+// format. The series are sorted so that the output is stable between scrapes.
+func (m *Metrics) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "greenlight_http_requests_total", "counter", "Total number of HTTP requests received.")
+	for _, key := range sortedRouteKeys(m.requests) {
+		fmt.Fprintf(w, "greenlight_http_requests_total{%s} %d\n", key.labels(), m.requests[key])
+	}
+
+	header(w, "greenlight_http_responses_total", "counter", "Total number of HTTP responses sent.")
+	responseKeys := make([]responseKey, 0, len(m.responses))
+	for key := range m.responses {
+		responseKeys = append(responseKeys, key)
+	}
+	sort.Slice(responseKeys, func(i, j int) bool {
+		if responseKeys[i].routeKey != responseKeys[j].routeKey {
+			return responseKeys[i].less(responseKeys[j].routeKey)
+		}
+		return responseKeys[i].status < responseKeys[j].status
+	})
+	for _, key := range responseKeys {
+		fmt.Fprintf(w, "greenlight_http_responses_total{%s,status=\"%d\"} %d\n", key.labels(), key.status, m.responses[key])
+	}
+
+	header(w, "greenlight_http_requests_in_flight", "gauge", "Number of HTTP requests currently being processed.")
+	inFlightKeys := make([]routeKey, 0, len(m.inFlight))
+	for key := range m.inFlight {
+		inFlightKeys = append(inFlightKeys, key)
+	}
+	sortRouteKeys(inFlightKeys)
+	for _, key := range inFlightKeys {
+		fmt.Fprintf(w, "greenlight_http_requests_in_flight{%s} %d\n", key.labels(), m.inFlight[key])
+	}
+
+	header(w, "greenlight_http_request_duration_seconds", "histogram", "HTTP request latency in seconds.")
+	durationKeys := make([]routeKey, 0, len(m.durations))
+	for key := range m.durations {
+		durationKeys = append(durationKeys, key)
+	}
+	sortRouteKeys(durationKeys)
+	for _, key := range durationKeys {
+		h := m.durations[key]
+		labels := key.labels()
+
+		var cumulative uint64
+		for i, upper := range buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "greenlight_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(upper), cumulative)
+		}
+		fmt.Fprintf(w, "greenlight_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "greenlight_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "greenlight_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// WriteDBStats writes the statistics for a database/sql connection pool to w in the
+// Prometheus text exposition format.
+func WriteDBStats(w io.Writer, stats sql.DBStats) {
+	gauges := []struct {
+		name, help string
+		value      int
+	}{
+		{"greenlight_db_max_open_connections", "Maximum number of open connections to the database.", stats.MaxOpenConnections},
+		{"greenlight_db_open_connections", "Number of established connections, both in use and idle.", stats.OpenConnections},
+		{"greenlight_db_in_use_connections", "Number of connections currently in use.", stats.InUse},
+		{"greenlight_db_idle_connections", "Number of idle connections.", stats.Idle},
+	}
+	for _, g := range gauges {
+		header(w, g.name, "gauge", g.help)
+		fmt.Fprintf(w, "%s %d\n", g.name, g.value)
+	}
+
+	counters := []struct {
+		name, help string
+		value      int64
+	}{
+		{"greenlight_db_wait_count_total", "Total number of connections waited for.", stats.WaitCount},
+		{"greenlight_db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.", stats.MaxIdleClosed},
+		{"greenlight_db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.", stats.MaxIdleTimeClosed},
+		{"greenlight_db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.", stats.MaxLifetimeClosed},
+	}
+	for _, c := range counters {
+		header(w, c.name, "counter", c.help)
+		fmt.Fprintf(w, "%s %d\n", c.name, c.value)
+	}
+
+	header(w, "greenlight_db_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.")
+	fmt.Fprintf(w, "greenlight_db_wait_duration_seconds_total %s\n", formatFloat(stats.WaitDuration.Seconds()))
+}
+
+// WriteRuntimeStats writes statistics about the Go runtime to w in the Prometheus text
+// exposition format. Note that runtime.ReadMemStats() briefly stops the world, which
+// is fine at the rate Prometheus scrapes but is worth knowing about.
+func WriteRuntimeStats(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	header(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+
+	gauges := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", ms.Alloc},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", ms.HeapInuse},
+		{"go_memstats_heap_objects", "Number of allocated heap objects.", ms.HeapObjects},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from the operating system.", ms.Sys},
+	}
+	for _, g := range gauges {
+		header(w, g.name, "gauge", g.help)
+		fmt.Fprintf(w, "%s %d\n", g.name, g.value)
+	}
+
+	header(w, "go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes_total %d\n", ms.TotalAlloc)
+
+	header(w, "go_gc_cycles_total", "counter", "Total number of completed GC cycles.")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", ms.NumGC)
+
+	header(w, "go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %s\n", formatFloat(time.Duration(ms.PauseTotalNs).Seconds()))
+}
+
+// The header() function writes the HELP and TYPE lines which precede each metric.
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// The labels() method returns the route and method labels for a routeKey, with the
+// values escaped as the exposition format requires.
+func (k routeKey) labels() string {
+	return fmt.Sprintf("route=\"%s\",method=\"%s\"", escape(k.route), escape(k.method))
+}
+
+func (k routeKey) less(other routeKey) bool {
+	if k.route != other.route {
+		return k.route < other.route
+	}
+	return k.method < other.method
+}
+
+func sortRouteKeys(keys []routeKey) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+}
+
+func sortedRouteKeys(m map[routeKey]uint64) []routeKey {
+	keys := make([]routeKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sortRouteKeys(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,393 @@
+// Package migrate applies the SQL migrations for the application to a PostgreSQL
+// database. It keeps track of the applied version in a schema_migrations table with
+// the same layout as the one used by the golang-migrate tool, so that databases which
+// were migrated with that tool can be managed by this package and vice versa.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Define custom errors which are returned when the database schema is in a dirty state
+// and when there are no migrations to apply.
+var (
+	ErrDirty    = errors.New("database schema is dirty, fix it manually and then use force")
+	ErrNoChange = errors.New("no change")
+)
+
+// lockID is the key for the PostgreSQL advisory lock which we take out while migrating,
+// so that two instances of the application starting at the same time can't both try to
+// apply the same migrations.
+const lockID = 7439027105
+
+// The filenameRX regular expression matches migration file names like
+// "000001_create_movies_table.up.sql".
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Define a Migration struct to hold the version number, name and SQL statements of a
+// single migration.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Logger is the interface used to report the progress of the migrations. It is
+// satisfied by *jsonlog.Logger.
+type Logger interface {
+	PrintInfo(message string, properties map[string]string)
+}
+
+// Define a Migrator type which applies migrations to the database.
+type Migrator struct {
+	db         *sql.DB
+	logger     Logger
+	migrations []Migration
+}
+
+// New reads the migration files from the root of fsys and returns a Migrator which
+// applies them to db. Every migration must have both an up and a down file. The logger
+// may be nil.
+func New(db *sql.DB, fsys fs.FS, logger Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		match := filenameRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names: %s and %s", version, m.Name, match[2])
+		}
+
+		switch match[3] {
+		case "up":
+			m.Up = string(content)
+		case "down":
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) must have both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{db: db, logger: logger, migrations: migrations}, nil
+}
+
+// Latest returns the version of the most recent migration, or 0 if there aren't any.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the current version of the database schema, and whether the last
+// migration failed part way through (in which case the schema is dirty). The version
+// is 0 if no migrations have been applied.
+func (m *Migrator) Version(ctx context.Context) (int, bool, error) {
+	var (
+		version int
+		dirty   bool
+	)
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, dirty, err = readVersion(ctx, conn)
+		return err
+	})
+
+	return version, dirty, err
+}
+
+// Up applies the next n migrations, or all of the pending migrations if n is less than
+// or equal to zero. It returns ErrNoChange if there are no migrations to apply.
+func (m *Migrator) Up(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		var pending []Migration
+		for _, migration := range m.migrations {
+			if migration.Version > current {
+				pending = append(pending, migration)
+			}
+		}
+
+		if n > 0 && n < len(pending) {
+			pending = pending[:n]
+		}
+		if len(pending) == 0 {
+			return ErrNoChange
+		}
+
+		for _, migration := range pending {
+			err := m.apply(ctx, conn, current, migration.Version, migration.Up)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) up: %w", migration.Version, migration.Name, err)
+			}
+
+			m.log("applied migration", migration, "up")
+			current = migration.Version
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back the last n applied migrations. It returns ErrNoChange if there are
+// no migrations to roll back.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("the number of migrations to roll back must be positive, got %d", n)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		var applied []Migration
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if m.migrations[i].Version <= current {
+				applied = append(applied, m.migrations[i])
+			}
+		}
+
+		if n < len(applied) {
+			applied = applied[:n]
+		}
+		if len(applied) == 0 {
+			return ErrNoChange
+		}
+
+		for _, migration := range applied {
+			// The version after rolling back this migration is the version of the
+			// previous migration, or 0 if it was the first.
+			target := 0
+			if idx := m.index(migration.Version); idx > 0 {
+				target = m.migrations[idx-1].Version
+			}
+
+			err := m.apply(ctx, conn, current, target, migration.Down)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) down: %w", migration.Version, migration.Name, err)
+			}
+
+			m.log("rolled back migration", migration, "down")
+			current = target
+		}
+
+		return nil
+	})
+}
+
+// Force sets the version of the database schema and clears the dirty flag, without
+// running any migrations. It's used to recover after a failed migration has been fixed
+// manually. A version of 0 means no migrations have been applied.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return setVersion(ctx, conn, version, false)
+	})
+}
+
+// The apply() method runs a single migration step. Before running the SQL we record the
+// target version as dirty, and then run the SQL and clear the dirty flag in the same
+// transaction. If the migration fails, the transaction is rolled back, so we know the
+// schema is unchanged and restore the previous version. The dirty flag is therefore
+// only left set if the process dies part way through a migration, which is the one
+// case where the state of the schema has to be checked manually.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, from, to int, statements string) error {
+	err := setVersion(ctx, conn, to, true)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, statements)
+	if err == nil {
+		err = setVersionTx(ctx, tx, to, false)
+	}
+	if err == nil {
+		return tx.Commit()
+	}
+
+	rollbackErr := tx.Rollback()
+	if rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed, schema left dirty: %s)", err, rollbackErr)
+	}
+
+	// Use a fresh context to restore the previous version, because the error may be
+	// that ctx was cancelled.
+	restoreErr := setVersion(context.Background(), conn, from, false)
+	if restoreErr != nil {
+		return fmt.Errorf("%w (restoring version %d failed, schema left dirty: %s)", err, from, restoreErr)
+	}
+
+	return err
+}
+
+// The withLock() method runs fn on a dedicated connection from the pool while holding
+// the migrations advisory lock. Advisory locks belong to a database session, so we
+// have to make sure that the lock, the migrations and the unlock all use the same
+// connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) index(version int) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *Migrator) log(message string, migration Migration, direction string) {
+	if m.logger == nil {
+		return
+	}
+
+	m.logger.PrintInfo(message, map[string]string{
+		"version":   strconv.Itoa(migration.Version),
+		"name":      migration.Name,
+		"direction": direction,
+	})
+}
+
+// The readVersion() function reads the current version and dirty flag from the
+// schema_migrations table, which holds at most one row.
+func readVersion(ctx context.Context, conn *sql.Conn) (int, bool, error) {
+	var (
+		version int
+		dirty   bool
+	)
+
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// The execer interface is satisfied by both *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// The setVersion() function replaces the row in the schema_migrations table in its
+// own transaction. A version of 0 is stored as no row at all, like golang-migrate does.
+func setVersion(ctx context.Context, conn *sql.Conn, version int, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = setVersionTx(ctx, tx, version, dirty)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setVersionTx(ctx context.Context, tx execer, version int, dirty bool) error {
+	_, err := tx.ExecContext(ctx, "TRUNCATE schema_migrations")
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)", version, dirty)
+	return err
+}
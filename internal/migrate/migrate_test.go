@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mrojasb2000/greenlight/migrations"
+)
+
+func TestNew(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_add_check.up.sql":      {Data: []byte("ALTER TABLE t ADD CHECK (n > 0);")},
+		"000002_add_check.down.sql":    {Data: []byte("ALTER TABLE t DROP CONSTRAINT t_n_check;")},
+		"000001_create_table.up.sql":   {Data: []byte("CREATE TABLE t (n int);")},
+		"000001_create_table.down.sql": {Data: []byte("DROP TABLE t;")},
+		"README.md":                    {Data: []byte("not a migration")},
+	}
+
+	m, err := New(nil, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.migrations) != 2 {
+		t.Fatalf("got %d migrations; want 2", len(m.migrations))
+	}
+	if m.migrations[0].Version != 1 || m.migrations[0].Name != "create_table" || m.migrations[0].Down != "DROP TABLE t;" {
+		t.Errorf("got first migration %+v", m.migrations[0])
+	}
+	if got := m.Latest(); got != 2 {
+		t.Errorf("got latest version %d; want 2", got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "Missing down file",
+			fsys: fstest.MapFS{
+				"000001_create_table.up.sql": {Data: []byte("CREATE TABLE t (n int);")},
+			},
+			wantErr: "must have both an up and a down file",
+		},
+		{
+			name: "Mismatched names",
+			fsys: fstest.MapFS{
+				"000001_create_table.up.sql":   {Data: []byte("CREATE TABLE t (n int);")},
+				"000001_create_other.down.sql": {Data: []byte("DROP TABLE t;")},
+			},
+			wantErr: "different names",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(nil, tt.fsys, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v; want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// The embedded migrations must always be valid, as they're applied by the api binary.
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil, migrations.FS, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range m.migrations {
+		if migration.Version != i+1 {
+			t.Errorf("got migration version %d at position %d; want versions to be sequential", migration.Version, i)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code IN ('books:read', 'books:write');
+DROP TABLE IF EXISTS books;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS books (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    authors text[] NOT NULL,
+    isbn text NOT NULL UNIQUE,
+    published_year integer NOT NULL,
+    pages integer NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+ALTER TABLE books ADD CONSTRAINT books_isbn_check CHECK (isbn ~ '^[0-9]{13}$');
+
+ALTER TABLE books ADD CONSTRAINT books_published_year_check CHECK (published_year BETWEEN 1 AND date_part('year', NOW()));
+
+ALTER TABLE books ADD CONSTRAINT books_pages_check CHECK (pages > 0);
+
+ALTER TABLE books ADD CONSTRAINT books_authors_length_check CHECK (array_length(authors, 1) BETWEEN 1 AND 10);
+
+-- Add the permissions for the books resource.
+INSERT INTO permissions (code)
+VALUES
+    ('books:read'),
+    ('books:write');
+
+-- Grant books:read to the existing users, as the registration handler does for new
+-- users, so that they can use the books endpoints straight away.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id FROM users, permissions WHERE permissions.code = 'books:read'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    operation text NOT NULL,
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    client text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Record the current version of each existing movie, so that every movie has at least
+-- one revision which it can be reverted to. The 'baseline' operation matches the
+-- OperationBaseline constant in internal/data/revisions.go.
+INSERT INTO movie_revisions (movie_id, version, operation, title, year, runtime, genres)
+SELECT id, version, 'baseline', title, year, runtime, genres FROM movies;
@@ -0,0 +1,5 @@
+-- Add a permission for the audit fields of movie revisions, which record the user and
+-- IP address responsible for each change. It isn't granted to anyone by default.
+INSERT INTO permissions (code)
+VALUES
+    ('movies:audit');
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migration files, so that the api binary can apply
+// them without needing a copy of this directory at runtime.
+package migrations
+
+import "embed"
+
+// FS holds the up and down SQL migration files in this directory.
+//
+//go:embed *.sql
+var FS embed.FS